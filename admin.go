@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AdminMiddleware only lets through requests carrying the configured admin
+// token as "Authorization: Bearer <token>".
+func AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg().AdminToken == "" {
+			httpError(w, "admin API is disabled", http.StatusForbidden)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg().AdminToken)) != 1 {
+			httpError(w, "admin token is required", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type TenantStats struct {
+	OrganizationID string `json:"organization_id"`
+	Kindergartens  int64  `json:"kindergartens"`
+	Error          string `json:"error,omitempty"`
+}
+
+// StatsTotals sums the tenants' counts. Users don't belong to a tenant;
+// they are counted once, in the central database.
+type StatsTotals struct {
+	Users         int64 `json:"users"`
+	Kindergartens int64 `json:"kindergartens"`
+}
+
+type Stats struct {
+	Tenants []TenantStats `json:"tenants"`
+	// Failed lists the tenants whose database could not be queried; they
+	// are left out of Totals.
+	Failed      []TenantStats `json:"failed"`
+	Totals      StatsTotals   `json:"totals"`
+	GeneratedAt time.Time     `json:"generated_at"`
+}
+
+var statsCache struct {
+	sync.Mutex
+	stats   *Stats
+	expires time.Time
+}
+
+func getStats(w http.ResponseWriter, r *http.Request) {
+	statsCache.Lock()
+	defer statsCache.Unlock()
+
+	if statsCache.stats == nil || time.Now().After(statsCache.expires) {
+		stats, err := collectStats(r.Context())
+		if err != nil {
+			httpError(w, "could not list organizations", http.StatusInternalServerError)
+			return
+		}
+		statsCache.stats = stats
+		statsCache.expires = stats.GeneratedAt.Add(cfg().StatsCacheTTL)
+	}
+	writeJSON(w, r, http.StatusOK, statsCache.stats)
+}
+
+func resetStatsCache() {
+	statsCache.Lock()
+	defer statsCache.Unlock()
+	statsCache.stats = nil
+}
+
+// collectStats counts the kindergartens of every tenant, querying at most
+// Config.StatsConcurrency tenant databases at a time, and the users. It
+// stops starting queries once ctx is done.
+func collectStats(ctx context.Context) (*Stats, error) {
+	var organizations []Organization
+	if err := centralDB.WithContext(ctx).Find(&organizations).Error; err != nil {
+		return nil, err
+	}
+	var users int64
+	if err := centralDB.WithContext(ctx).Model(&User{}).Count(&users).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]TenantStats, len(organizations))
+	sem := make(chan struct{}, max(cfg().StatsConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, org := range organizations {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = tenantStats(ctx, org)
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		Tenants:     []TenantStats{},
+		Failed:      []TenantStats{},
+		Totals:      StatsTotals{Users: users},
+		GeneratedAt: time.Now().UTC(),
+	}
+	for _, s := range results {
+		if s.Error != "" {
+			stats.Failed = append(stats.Failed, s)
+			continue
+		}
+		stats.Tenants = append(stats.Tenants, s)
+		stats.Totals.Kindergartens += s.Kindergartens
+	}
+	return stats, nil
+}
+
+func tenantStats(ctx context.Context, org Organization) TenantStats {
+	s := TenantStats{OrganizationID: org.ID}
+
+	tc, err := parseTenantConfig(org.ID, org.Config)
+	if err != nil {
+		s.Error = "invalid tenant config"
+		return s
+	}
+	tenantDB, err := getTenantReplicaDB(ctx, tc)
+	if err != nil {
+		s.Error = "failed to connect to tenant database"
+		return s
+	}
+	if err := tenantDB.Model(&Kindergarten{}).Count(&s.Kindergartens).Error; err != nil {
+		s.Error = "could not count kindergartens"
+		return s
+	}
+	return s
+}
+
+type ConnectionTest struct {
+	Config string `json:"config"`
+	// OrganizationID renders Config.TenantDSNTemplate when Config has no
+	// DSN.
+	OrganizationID string `json:"organization_id,omitempty"`
+}
+
+type ConnectionTestResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// testConnection checks that a tenant config can be connected to, without
+// saving anything. The connection is closed right away.
+func testConnection(w http.ResponseWriter, r *http.Request) {
+	var test ConnectionTest
+	if err := decodeJSON(r, &test); err != nil {
+		writeError(w, err)
+		return
+	}
+	tc, err := parseTenantConfig(test.OrganizationID, test.Config)
+	if err != nil {
+		writeError(w, newError(ErrUnprocessable, "invalid tenant config", err))
+		return
+	}
+	if tc.DSN == "" {
+		writeError(w, newError(ErrUnprocessable, "DSN is required", nil))
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, connectionTestResult(r.Context(), tc.DSN))
+}
+
+func connectionTestResult(ctx context.Context, dsn string) ConnectionTestResult {
+	fail := func(err error) ConnectionTestResult {
+		return ConnectionTestResult{Error: redactDSN(err.Error(), dsn)}
+	}
+
+	// Opening a SQLite file that doesn't exist would create it.
+	if err := checkTenantDBExists(dsn); err != nil {
+		return fail(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg().TenantOpenTimeout)
+	defer cancel()
+	db, err := openTenantDB(ctx, dsn)
+	if err != nil {
+		return fail(err)
+	}
+	defer closeDB(db)
+
+	if err := pingDB(ctx, db); err != nil {
+		return fail(err)
+	}
+	return ConnectionTestResult{OK: true}
+}
+
+// Health reports the state of the service's dependencies.
+type Health struct {
+	Central  string          `json:"central"`
+	Breakers []BreakerStatus `json:"breakers"`
+}
+
+// getHealth checks the central database and lists the circuit breakers of
+// failing tenants.
+func getHealth(w http.ResponseWriter, r *http.Request) {
+	health := Health{Central: "ok", Breakers: tenantBreakerStatuses()}
+	status := http.StatusOK
+	if err := pingDB(r.Context(), centralDB); err != nil {
+		log.Printf("central database: %v", err)
+		health.Central = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, r, status, health)
+}
+
+func getPasswordHashReport(w http.ResponseWriter, r *http.Request) {
+	report, err := userService.PasswordHashReport(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, report)
+}
+
+// getTenantConfig shows the configuration a tenant runs with.
+func getTenantConfig(w http.ResponseWriter, r *http.Request) {
+	org, err := organizationService.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	tc, err := parseTenantConfig(org.ID, org.Config)
+	if err != nil {
+		writeError(w, newError(ErrUnprocessable, "invalid tenant config: "+redactDSN(err.Error(), org.Config), err))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, effectiveTenantConfig(org, tc))
+}
+
+type OrganizationRename struct {
+	ID string `json:"id"`
+}
+
+// renameOrganization changes the ID of an organization. See
+// OrganizationService.Rename.
+func renameOrganization(w http.ResponseWriter, r *http.Request) {
+	var rename OrganizationRename
+	if err := decodeJSON(r, &rename); err != nil {
+		writeError(w, err)
+		return
+	}
+	if rename.ID == "" {
+		writeError(w, newError(ErrUnprocessable, "id is required", nil))
+		return
+	}
+	if !tenantIDFormat.MatchString(rename.ID) {
+		writeError(w, fieldError("id", "must be "+tenantIDFormatMessage, nil))
+		return
+	}
+	org, err := organizationService.Rename(r.Context(), chi.URLParam(r, "id"), rename.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, org)
+}
+
+// migrateTenant re-runs the migrations of one organization's tenant
+// database and reports the schema version it ends up on.
+func migrateTenant(w http.ResponseWriter, r *http.Request) {
+	org, err := organizationService.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status, err := forceMigrateOrganization(r.Context(), org)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if status.Error != "" {
+		log.Printf("tenant %s: forced migration failed at version %q: %s", org.ID, status.Version, status.Error)
+		writeJSON(w, r, http.StatusInternalServerError, status)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, status)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGetStats(t *testing.T) {
+	s := newTestServer(t)
+	for tenant, n := range map[string]int{"acme": 2, "beta": 3} {
+		s.createTenant(tenant)
+		for i := 0; i < n; i++ {
+			wantStatus(t, s.tenant(tenant, "POST", "/kindergartens", Kindergarten{Name: fmt.Sprint("K", i)}), http.StatusCreated)
+		}
+	}
+	// A tenant whose database is gone is reported, not counted.
+	gone := Organization{ID: "gone", Name: "Gone", Config: s.tenantConfig("gone"), Status: StatusActive}
+	if err := centralDB.Create(&gone).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"erin", "frank"} {
+		wantStatus(t, s.do("POST", "/users", User{Username: username, Password: "secret"}), http.StatusOK)
+	}
+
+	rec := s.admin("GET", "/admin/stats", nil)
+	wantStatus(t, rec, http.StatusOK)
+	stats := decode[Stats](t, rec)
+	// Each tenant also has the two seed kindergartens.
+	if want := (StatsTotals{Users: 2, Kindergartens: 9}); stats.Totals != want {
+		t.Errorf("totals = %+v, want %+v", stats.Totals, want)
+	}
+	if len(stats.Tenants) != 2 {
+		t.Errorf("tenants = %+v, want acme and beta", stats.Tenants)
+	}
+	if len(stats.Failed) != 1 || stats.Failed[0].OrganizationID != "gone" {
+		t.Errorf("failed = %+v, want gone", stats.Failed)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+const apiKeyKey contextKey = "apiKey"
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to spot
+// in logs and by secret scanners.
+const apiKeyPrefix = "mtgo_"
+
+// APIKey lets a server-to-server integration call the tenant routes of
+// one organization with "Authorization: ApiKey <key>". Only the SHA-256
+// hash of the key is stored; keys are random and long, so a slow hash
+// would add nothing. The key itself is returned once, when it is minted.
+type APIKey struct {
+	ID             uint   `gorm:"primaryKey" json:"id"`
+	OrganizationID string `gorm:"index" json:"organization_id"`
+	Name           string `json:"name"`
+	// Hint is the start of the key, for telling keys apart.
+	Hint string `json:"hint"`
+	Hash string `gorm:"uniqueIndex" json:"-"`
+	// Scopes are the apiScopes the key was given, see RequireScope.
+	Scopes    []string   `gorm:"serializer:json" json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MintedAPIKey is an APIKey as minting returns it, the only time the key
+// is shown.
+type MintedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// newAPIKey returns a random API key.
+func newAPIKey() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return apiKeyPrefix + hex.EncodeToString(b)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKey returns the API key TenantMiddleware authenticated the request
+// with, or nil for requests without one.
+func apiKey(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyKey).(*APIKey)
+	return key
+}
+
+// authenticateAPIKey looks up the key of an "Authorization: ApiKey <key>"
+// header. It returns nil for requests with another kind of Authorization,
+// or none, and fails with ErrUnauthorized for keys that are unknown,
+// revoked or expired.
+func authenticateAPIKey(r *http.Request) (*APIKey, error) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey ")
+	if !ok {
+		return nil, nil
+	}
+	var key APIKey
+	err := centralDB.WithContext(r.Context()).Where("hash = ?", hashAPIKey(strings.TrimSpace(raw))).First(&key).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, newError(ErrUnauthorized, "invalid API key", err)
+	case err != nil:
+		return nil, newError(nil, "could not check API key", err)
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		return nil, newError(ErrUnauthorized, "invalid API key", nil)
+	}
+	return &key, nil
+}
+
+// mintAPIKey creates an API key for the organization. The body may set
+// the key's name, scopes and expires_at.
+func mintAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := validateScopes(req.Scopes); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		writeError(w, newError(ErrUnprocessable, "expires_at must be in the future", nil))
+		return
+	}
+	org, err := organizationService.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	raw := newAPIKey()
+	key := APIKey{
+		OrganizationID: org.ID,
+		Name:           req.Name,
+		Hint:           raw[:len(apiKeyPrefix)+6],
+		Hash:           hashAPIKey(raw),
+		Scopes:         req.Scopes,
+		ExpiresAt:      req.ExpiresAt,
+	}
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+	if err := centralDB.WithContext(r.Context()).Create(&key).Error; err != nil {
+		writeError(w, newError(nil, "could not create API key", err))
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, MintedAPIKey{APIKey: key, Key: raw})
+}
+
+// listAPIKeys lists the organization's API keys, revoked ones included,
+// without the keys themselves.
+func listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys := []APIKey{}
+	err := centralDB.WithContext(r.Context()).Where("organization_id = ?", chi.URLParam(r, "id")).Order("id").Find(&keys).Error
+	if err != nil {
+		writeError(w, newError(nil, "could not list API keys", err))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, keys)
+}
+
+// revokeAPIKey revokes one of the organization's API keys for good.
+// Revoking a revoked key changes nothing.
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "keyID"), 10, 64)
+	if err != nil {
+		writeError(w, newError(ErrNotFound, "API key not found", err))
+		return
+	}
+	res := centralDB.WithContext(r.Context()).Model(&APIKey{}).
+		Where("id = ? AND organization_id = ?", id, chi.URLParam(r, "id")).
+		Update("revoked_at", gorm.Expr("COALESCE(revoked_at, ?)", time.Now().UTC()))
+	if res.Error != nil {
+		writeError(w, newError(nil, "could not revoke API key", res.Error))
+		return
+	}
+	if res.RowsAffected == 0 {
+		writeError(w, newError(ErrNotFound, "API key not found", nil))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
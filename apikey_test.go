@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeys(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	s.createTenant("beta")
+
+	rec := s.admin("POST", "/admin/tenants/acme/api-keys", map[string]interface{}{"name": "sync", "scopes": []string{"kindergartens:read"}})
+	wantStatus(t, rec, http.StatusCreated)
+	minted := decode[MintedAPIKey](t, rec)
+	auth := "ApiKey " + minted.Key
+
+	// Only the hash is stored, and the key isn't listed.
+	var stored APIKey
+	if err := centralDB.First(&stored, minted.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Hash != hashAPIKey(minted.Key) || stored.Hash == minted.Key {
+		t.Errorf("stored hash %q for key %q", stored.Hash, minted.Key)
+	}
+	rec = s.admin("GET", "/admin/tenants/acme/api-keys", nil)
+	wantStatus(t, rec, http.StatusOK)
+	if strings.Contains(rec.Body.String(), minted.Key) || !strings.Contains(rec.Body.String(), minted.Hint) {
+		t.Errorf("listed keys %s, want the hint and not the key", rec.Body.String())
+	}
+
+	// The key names its tenant.
+	wantStatus(t, s.do("GET", "/kindergartens", nil, "Authorization", auth), http.StatusOK)
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens", nil, "Authorization", auth), http.StatusOK)
+	wantStatus(t, s.tenant("beta", "GET", "/kindergartens", nil, "Authorization", auth), http.StatusForbidden)
+	wantStatus(t, s.do("GET", "/kindergartens", nil, "Authorization", "ApiKey "+newAPIKey()), http.StatusUnauthorized)
+
+	expired := time.Now().Add(-time.Hour)
+	old := APIKey{OrganizationID: "acme", Name: "old", Hash: hashAPIKey("expired-key"), Scopes: []string{"kindergartens:read"}, ExpiresAt: &expired}
+	if err := centralDB.Create(&old).Error; err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, s.do("GET", "/kindergartens", nil, "Authorization", "ApiKey expired-key"), http.StatusUnauthorized)
+	wantStatus(t, s.admin("POST", "/admin/tenants/acme/api-keys", map[string]interface{}{"name": "late", "expires_at": expired}), http.StatusUnprocessableEntity)
+
+	wantStatus(t, s.admin("DELETE", fmt.Sprint("/admin/tenants/beta/api-keys/", minted.ID), nil), http.StatusNotFound)
+	wantStatus(t, s.admin("DELETE", fmt.Sprint("/admin/tenants/acme/api-keys/", minted.ID), nil), http.StatusNoContent)
+	wantStatus(t, s.do("GET", "/kindergartens", nil, "Authorization", auth), http.StatusUnauthorized)
+
+	// Deleting the organization deletes its keys.
+	wantStatus(t, s.do("DELETE", "/organizations/acme", nil), http.StatusNoContent)
+	var left int64
+	centralDB.Model(&APIKey{}).Where("organization_id = ?", "acme").Count(&left)
+	if left != 0 {
+		t.Errorf("%d API keys left after deleting their organization", left)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBatchGetOrganizations(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.BatchGetMaxIDs = 3 })
+	s.createTenant("acme")
+	s.createTenant("beta")
+
+	rec := s.do("POST", "/organizations/batch-get", BatchGet{IDs: []string{"beta", "missing", "acme"}})
+	wantStatus(t, rec, http.StatusOK)
+	results := decode[[]BatchGetResult](t, rec)
+	want := []struct {
+		id    string
+		found bool
+	}{{"beta", true}, {"missing", false}, {"acme", true}}
+	if len(results) != len(want) {
+		t.Fatalf("results = %+v, want %d", results, len(want))
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.ID != w.id || r.Found != w.found {
+			t.Errorf("result %d = %s found %v, want %s found %v", i, r.ID, r.Found, w.id, w.found)
+		}
+		if found := r.Organization != nil && r.Organization.ID == w.id; found != w.found {
+			t.Errorf("result %d organization = %+v", i, r.Organization)
+		}
+	}
+
+	for name, ids := range map[string][]string{
+		"none":     {},
+		"too many": {"a", "b", "c", "d"},
+	} {
+		rec := s.do("POST", "/organizations/batch-get", BatchGet{IDs: ids})
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: status = %d, want %d", name, rec.Code, http.StatusUnprocessableEntity)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned for tenant databases whose circuit breaker is
+// open.
+var errCircuitOpen = errors.New("circuit breaker open")
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half_open"
+)
+
+// circuitBreaker stops opening a tenant database after
+// Config.BreakerThreshold consecutive failures. Once open, it fails fast
+// for Config.BreakerCooldown, then lets a single probe through: the probe
+// closes it again on success and reopens it on failure.
+type circuitBreaker struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// BreakerStatus is a circuit breaker as reported by /admin/health.
+type BreakerStatus struct {
+	Tenant   string       `json:"tenant"`
+	State    breakerState `json:"state"`
+	Failures int          `json:"failures"`
+}
+
+// tenantBreakers holds a circuit breaker per tenant DSN.
+var tenantBreakers = struct {
+	sync.Mutex
+	m map[string]*circuitBreaker
+}{m: map[string]*circuitBreaker{}}
+
+// allowTenantOpen reports whether the tenant database may be opened now.
+func allowTenantOpen(dsn string, now time.Time) error {
+	if cfg().BreakerThreshold <= 0 {
+		return nil
+	}
+	tenantBreakers.Lock()
+	defer tenantBreakers.Unlock()
+	b, ok := tenantBreakers.m[dsn]
+	if !ok {
+		return nil
+	}
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < cfg().BreakerCooldown {
+			return errCircuitOpen
+		}
+		b.state = breakerHalfOpen
+	case breakerHalfOpen:
+		if b.probing {
+			return errCircuitOpen
+		}
+	default:
+		return nil
+	}
+	b.probing = true
+	return nil
+}
+
+// recordTenantOpen feeds the outcome of opening a tenant database to its
+// breaker. Requests cancelled by the client say nothing about the database
+// and are ignored.
+func recordTenantOpen(dsn string, err error, now time.Time) {
+	threshold := cfg().BreakerThreshold
+	if threshold <= 0 || errors.Is(err, context.Canceled) {
+		return
+	}
+	tenantBreakers.Lock()
+	defer tenantBreakers.Unlock()
+	b, ok := tenantBreakers.m[dsn]
+
+	if err == nil {
+		if ok {
+			delete(tenantBreakers.m, dsn)
+		}
+		return
+	}
+	if !ok {
+		b = &circuitBreaker{state: breakerClosed}
+		tenantBreakers.m[dsn] = b
+	}
+	b.failures++
+	b.probing = false
+	if b.state == breakerHalfOpen || b.failures >= threshold {
+		b.state = breakerOpen
+		b.openedAt = now
+	}
+}
+
+// tenantBreakerStatuses lists the breakers of the tenants that have been
+// failing, with passwords removed from their DSNs.
+func tenantBreakerStatuses() []BreakerStatus {
+	tenantBreakers.Lock()
+	defer tenantBreakers.Unlock()
+	statuses := make([]BreakerStatus, 0, len(tenantBreakers.m))
+	for dsn, b := range tenantBreakers.m {
+		statuses = append(statuses, BreakerStatus{Tenant: redactDSN(dsn, dsn), State: b.state, Failures: b.failures})
+	}
+	return statuses
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		c.BreakerThreshold = 2
+		c.BreakerCooldown = time.Minute
+	})
+	const dsn = "postgres://app:hunter2@db:5432/acme"
+	failed := errors.New("connection refused")
+	now := time.Now()
+	state := func() breakerState {
+		t.Helper()
+		tenantBreakers.Lock()
+		defer tenantBreakers.Unlock()
+		if b, ok := tenantBreakers.m[dsn]; ok {
+			return b.state
+		}
+		return breakerClosed
+	}
+	allow := func(want bool) {
+		t.Helper()
+		if err := allowTenantOpen(dsn, now); (err == nil) != want {
+			t.Fatalf("allowTenantOpen = %v in state %s, want allowed %v", err, state(), want)
+		}
+	}
+
+	allow(true)
+	recordTenantOpen(dsn, failed, now)
+	allow(true)
+	recordTenantOpen(dsn, failed, now)
+	if state() != breakerOpen {
+		t.Fatalf("state = %s after 2 failures, want open", state())
+	}
+	allow(false)
+
+	rec := s.admin("GET", "/admin/health", nil)
+	wantStatus(t, rec, http.StatusOK)
+	health := decode[Health](t, rec)
+	if len(health.Breakers) != 1 || health.Breakers[0].State != breakerOpen || health.Breakers[0].Tenant != redactDSN(dsn, dsn) {
+		t.Errorf("breakers = %+v, want acme open with its password redacted", health.Breakers)
+	}
+
+	// After the cooldown a single probe goes through; its failure reopens
+	// the breaker at once.
+	now = now.Add(time.Minute)
+	allow(true)
+	if state() != breakerHalfOpen {
+		t.Fatalf("state = %s after the cooldown, want half_open", state())
+	}
+	allow(false)
+	recordTenantOpen(dsn, failed, now)
+	if state() != breakerOpen {
+		t.Fatalf("state = %s after a failed probe, want open", state())
+	}
+	allow(false)
+
+	now = now.Add(time.Minute)
+	allow(true)
+	recordTenantOpen(dsn, nil, now)
+	if state() != breakerClosed {
+		t.Fatalf("state = %s after a successful probe, want closed", state())
+	}
+	allow(true)
+	if got := tenantBreakerStatuses(); len(got) != 0 {
+		t.Errorf("breakers = %+v after recovering, want none", got)
+	}
+}
+
+func TestCircuitBreakerRefusesTenant(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		c.BreakerThreshold = 2
+		c.BreakerCooldown = time.Minute
+	})
+	// Its database is gone.
+	gone := Organization{ID: "gone", Name: "Gone", Config: s.tenantConfig("gone"), Status: StatusActive}
+	if err := centralDB.Create(&gone).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := s.tenant("gone", "GET", "/kindergartens", nil)
+		wantStatus(t, rec, http.StatusServiceUnavailable)
+		if got := strings.TrimSpace(rec.Body.String()); got != "tenant database does not exist" {
+			t.Errorf("request %d: error = %q", i+1, got)
+		}
+	}
+	rec := s.tenant("gone", "GET", "/kindergartens", nil)
+	wantStatus(t, rec, http.StatusServiceUnavailable)
+	if got := strings.TrimSpace(rec.Body.String()); got != "tenant database unavailable" {
+		t.Errorf("with the breaker open: error = %q", got)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// responseCache stores the responses of tenant reads. Entries are kept
+// per tenant, so that a key is never looked up in another tenant's entries
+// and a tenant's can be dropped together.
+type responseCache interface {
+	get(tenant, key string, now time.Time) (cachedResponse, bool)
+	set(tenant, key string, resp cachedResponse, expires time.Time)
+	invalidate(tenant string)
+}
+
+type cachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// memoryCache keeps the responses in memory. Each instance has its own, and
+// only drops the entries of the writes it serves itself; another
+// instance's can be stale for up to Config.ResponseCacheTTL.
+type memoryCache struct {
+	mu      sync.Mutex
+	tenants map[string]map[string]cacheEntry
+	pruned  time.Time
+}
+
+type cacheEntry struct {
+	resp    cachedResponse
+	expires time.Time
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{tenants: map[string]map[string]cacheEntry{}}
+}
+
+func (c *memoryCache) get(tenant, key string, now time.Time) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.tenants[tenant][key]
+	if !ok || now.After(e.expires) {
+		return cachedResponse{}, false
+	}
+	return e.resp, true
+}
+
+func (c *memoryCache) set(tenant, key string, resp cachedResponse, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.pruned) > time.Minute {
+		c.prune(time.Now())
+	}
+	entries, ok := c.tenants[tenant]
+	if !ok {
+		entries = map[string]cacheEntry{}
+		c.tenants[tenant] = entries
+	}
+	entries[key] = cacheEntry{resp: resp, expires: expires}
+}
+
+func (c *memoryCache) invalidate(tenant string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tenants, tenant)
+}
+
+// prune drops the expired entries, so that entries nobody reads again
+// don't pile up.
+func (c *memoryCache) prune(now time.Time) {
+	for tenant, entries := range c.tenants {
+		for key, e := range entries {
+			if now.After(e.expires) {
+				delete(entries, key)
+			}
+		}
+		if len(entries) == 0 {
+			delete(c.tenants, tenant)
+		}
+	}
+	c.pruned = now
+}
+
+var tenantCache responseCache = newMemoryCache()
+
+// CacheTenantReads serves repeated GET requests of a tenant from
+// tenantCache for Config.ResponseCacheTTL, keyed by their API version, path
+// and query.
+// Any other request that succeeds drops the tenant's cached responses,
+// since it may have changed them. It must run after TenantMiddleware, and
+// reports X-Cache: HIT or MISS.
+func CacheTenantReads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ttl := cfg().ResponseCacheTTL
+		tenant := tenantID(r.Context())
+		if ttl <= 0 || tenant == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			if rec.status < http.StatusBadRequest {
+				tenantCache.invalidate(tenant)
+			}
+			return
+		}
+
+		// Streamed lists would have to be held in memory whole.
+		if wantsStream(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The version can come from the Accept header, which proxies and
+		// browsers must then know the response depends on.
+		key := fmt.Sprintf("v%d %s", apiVersion(r.Context()), r.URL.RequestURI())
+		w.Header().Add("Vary", "Accept")
+		now := time.Now()
+		if resp, ok := tenantCache.get(tenant, key, now); ok {
+			h := w.Header()
+			for k, v := range resp.Header {
+				h[k] = slices.Clone(v)
+			}
+			h.Set("X-Cache", "HIT")
+			w.WriteHeader(resp.Status)
+			w.Write(resp.Body)
+			return
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		before := w.Header().Clone()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK, record: true}
+		next.ServeHTTP(rec, r)
+		if rec.status != http.StatusOK {
+			return
+		}
+		// Only the headers the handler set belong to the response; the
+		// others, such as the rate limit's, are the request's own.
+		header := http.Header{}
+		for k, v := range w.Header() {
+			if !slices.Equal(v, before[k]) {
+				header[k] = slices.Clone(v)
+			}
+		}
+		tenantCache.set(tenant, key, cachedResponse{Status: rec.status, Header: header, Body: rec.body}, now.Add(ttl))
+	})
+}
+
+// responseRecorder passes a response through while noting its status and,
+// if record is set, its body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	record      bool
+	body        []byte
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.wroteHeader = true
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	if rec.record {
+		rec.body = append(rec.body, b...)
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
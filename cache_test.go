@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCacheTenantReads(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.ResponseCacheTTL = time.Minute })
+	s.createTenant("acme")
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens", Kindergarten{Name: "Sunflower"}), http.StatusCreated)
+
+	get := func(accept, want string) string {
+		t.Helper()
+		rec := s.tenant("acme", "GET", "/kindergartens", nil, "Accept", accept)
+		wantStatus(t, rec, http.StatusOK)
+		if got := rec.Header().Get("X-Cache"); got != want {
+			t.Errorf("Accept %s: X-Cache = %s, want %s", accept, got, want)
+		}
+		if got := rec.Header().Values("Vary"); len(got) != 1 || got[0] != "Accept" {
+			t.Errorf("Accept %s: Vary = %q, want Accept", accept, got)
+		}
+		return rec.Body.String()
+	}
+	const v1, v2 = "application/json", "application/vnd.app.v2+json"
+	body1 := get(v1, "MISS")
+	if got := get(v1, "HIT"); got != body1 {
+		t.Errorf("cached body = %s, want %s", got, body1)
+	}
+	// Each version has its own entry.
+	body2 := get(v2, "MISS")
+	if body2 == body1 {
+		t.Errorf("v2 body = v1 body %s", body1)
+	}
+	if got := get(v2, "HIT"); got != body2 {
+		t.Errorf("cached v2 body = %s, want %s", got, body2)
+	}
+
+	// A write drops the tenant's entries.
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens", Kindergarten{Name: "Daisy"}), http.StatusCreated)
+	get(v1, "MISS")
+	get(v2, "MISS")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// cancelOnTenantQuery makes the first query to the databases of the given
+// tenants cancel the returned context, and counts the queries made.
+func cancelOnTenantQuery(s *testServer, ids ...string) (context.Context, *atomic.Int32) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.t.Cleanup(cancel)
+	var queries atomic.Int32
+	for _, id := range ids {
+		err := s.tenantDB(id).Callback().Query().Before("gorm:query").Register("test:cancel", func(*gorm.DB) {
+			queries.Add(1)
+			cancel()
+		})
+		if err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	return ctx, &queries
+}
+
+func TestListOrganizationsStopsWhenCancelled(t *testing.T) {
+	s := newTestServer(t)
+	ids := []string{"acme", "beta", "gamma"}
+	for _, id := range ids {
+		s.createTenant(id)
+	}
+	ctx, queries := cancelOnTenantQuery(s, ids...)
+
+	_, err := organizationService.List(ctx, Page{}, OrganizationFilter{})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("err = %v, want %v", err, ErrUnavailable)
+	}
+	if n := queries.Load(); n != 1 {
+		t.Errorf("tenant queries = %d, want 1", n)
+	}
+}
+
+func TestCollectStatsStopsWhenCancelled(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.StatsConcurrency = 1 })
+	ids := []string{"acme", "beta", "gamma"}
+	for _, id := range ids {
+		s.createTenant(id)
+	}
+	ctx, queries := cancelOnTenantQuery(s, ids...)
+
+	if _, err := collectStats(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	if n := queries.Load(); n != 1 {
+		t.Errorf("tenant queries = %d, want 1", n)
+	}
+}
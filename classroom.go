@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// Classroom belongs to a kindergarten of the same tenant database, see
+// Kindergarten.Classrooms.
+type Classroom struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	KindergartenID string    `gorm:"not null;index" json:"kindergarten_id"`
+	Name           string    `json:"name"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (c Classroom) validate() error {
+	return checkLength("name", c.Name, cfg().NameMinLength, cfg().NameMaxLength)
+}
+
+// classroomKindergarten loads the kindergarten of the classroom routes.
+func classroomKindergarten(r *http.Request) (*gorm.DB, Kindergarten, error) {
+	tenantDB := r.Context().Value("tenantDB").(*gorm.DB).WithContext(r.Context())
+
+	var kindergarten Kindergarten
+	err := firstOr404(tenantDB, &kindergarten, chi.URLParam(r, "id"))
+	return tenantDB, kindergarten, err
+}
+
+// findClassroom loads a classroom of the kindergarten, reporting classrooms
+// of other kindergartens as not found.
+func findClassroom(r *http.Request) (*gorm.DB, Classroom, error) {
+	tenantDB, kindergarten, err := classroomKindergarten(r)
+	if err != nil {
+		return nil, Classroom{}, err
+	}
+	var classroom Classroom
+	err = firstOr404(tenantDB.Where("kindergarten_id = ?", kindergarten.ID), &classroom, chi.URLParam(r, "classroomID"))
+	return tenantDB, classroom, err
+}
+
+func listClassrooms(w http.ResponseWriter, r *http.Request) {
+	tenantDB, kindergarten, err := classroomKindergarten(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	page, err := parsePage(r, "id", "name")
+	if err != nil {
+		writeError(w, newError(ErrBadRequest, err.Error(), nil))
+		return
+	}
+	classrooms := tenantDB.Model(&kindergarten).Association("Classrooms")
+	if wantsTotal(r) {
+		if page.Total = classrooms.Count(); classrooms.Error != nil {
+			writeError(w, newError(nil, "could not list classrooms", classrooms.Error))
+			return
+		}
+	}
+
+	var list []Classroom
+	if err := page.paginate(tenantDB.Where("kindergarten_id = ?", kindergarten.ID)).Find(&list).Error; err != nil {
+		writeError(w, newError(nil, "could not list classrooms", err))
+		return
+	}
+	err = page.checkTruncated(len(list), func() (int64, error) {
+		n := classrooms.Count()
+		return n, classrooms.Error
+	})
+	if err != nil {
+		writeError(w, newError(nil, "could not list classrooms", err))
+		return
+	}
+	writeList(w, r, list, page)
+}
+
+func createClassroom(w http.ResponseWriter, r *http.Request) {
+	tenantDB, kindergarten, err := classroomKindergarten(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var classroom Classroom
+	if err := decodeJSON(r, &classroom); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := classroom.validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	classroom.ID = 0
+	if err := tenantDB.Model(&kindergarten).Association("Classrooms").Append(&classroom); err != nil {
+		writeError(w, newError(nil, "could not create classroom", err))
+		return
+	}
+	if err := firstOr404(tenantDB, &classroom, strconv.FormatUint(uint64(classroom.ID), 10)); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(classroom))
+	writeJSON(w, r, http.StatusCreated, classroom)
+}
+
+func getClassroom(w http.ResponseWriter, r *http.Request) {
+	_, classroom, err := findClassroom(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(classroom))
+	writeJSON(w, r, http.StatusOK, classroom)
+}
+
+func updateClassroom(w http.ResponseWriter, r *http.Request) {
+	tenantDB, classroom, err := findClassroom(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !ifMatch(r, etag(classroom)) {
+		writeError(w, newError(ErrPreconditionFailed, "classroom has been modified", nil))
+		return
+	}
+	var update Classroom
+	if err := decodeJSON(r, &update); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := update.validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	// Classrooms don't move between kindergartens.
+	classroom.Name = update.Name
+	if err := tenantDB.Save(&classroom).Error; err != nil {
+		writeError(w, newError(nil, "could not update classroom", err))
+		return
+	}
+	w.Header().Set("ETag", etag(classroom))
+	writeJSON(w, r, http.StatusOK, classroom)
+}
+
+func deleteClassroom(w http.ResponseWriter, r *http.Request) {
+	tenantDB, classroom, err := findClassroom(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := tenantDB.Delete(&classroom).Error; err != nil {
+		writeError(w, newError(nil, "could not delete classroom", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestClassrooms(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	for _, id := range []string{"k1", "k2"} {
+		if err := s.tenantDB("acme").Create(&Kindergarten{ID: id, Name: "Kindergarten " + id}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := s.tenant("acme", "POST", "/kindergartens/k1/classrooms", Classroom{Name: "Sunflowers", KindergartenID: "k2"})
+	wantStatus(t, rec, http.StatusCreated)
+	classroom := decode[Classroom](t, rec)
+	if classroom.KindergartenID != "k1" {
+		t.Errorf("classroom belongs to %s, want k1", classroom.KindergartenID)
+	}
+	path := "/kindergartens/k1/classrooms/" + fmt.Sprint(classroom.ID)
+
+	classroom.Name = "Daisies"
+	wantStatus(t, s.tenant("acme", "PUT", path, classroom), http.StatusOK)
+	if got := decode[Classroom](t, s.tenant("acme", "GET", path, nil)); got.Name != "Daisies" {
+		t.Errorf("name = %q, want Daisies", got.Name)
+	}
+	// A classroom isn't reachable through another kindergarten.
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens/k2/classrooms/"+fmt.Sprint(classroom.ID), nil), http.StatusNotFound)
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens/missing/classrooms", Classroom{Name: "Tulips"}), http.StatusNotFound)
+
+	list := decode[[]Classroom](t, s.tenant("acme", "GET", "/kindergartens/k1/classrooms", nil))
+	if len(list) != 1 || list[0].ID != classroom.ID {
+		t.Errorf("classrooms = %+v, want %d", list, classroom.ID)
+	}
+
+	// Deleting the kindergarten for good deletes its classrooms.
+	wantStatus(t, s.tenant("acme", "DELETE", "/kindergartens/k1?permanent=true", nil), http.StatusNoContent)
+	var left int64
+	if err := s.tenantDB("acme").Model(&Classroom{}).Count(&left).Error; err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d classrooms left after deleting their kindergarten", left)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// cloneBatchSize is the number of rows copied per transaction when cloning
+// a tenant.
+const cloneBatchSize = 500
+
+type CloneResult struct {
+	Organization  Organization `json:"organization"`
+	Kindergartens int64        `json:"kindergartens"`
+	Classrooms    int64        `json:"classrooms"`
+	Settings      int64        `json:"settings"`
+}
+
+// cloneTenant copies the data of an organization's tenant database into a
+// new organization. The request body is the new organization, which needs
+// its own id and config; its status, active unless set, must be one an
+// organization may move to from StatusProvisioning.
+//
+// The new organization is created first, in StatusProvisioning, which
+// reserves its ID and keeps requests out of its database while the rows
+// are copied. If the copy fails, the organization is deleted and the rows
+// copied so far are removed again.
+func cloneTenant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	source, err := organizationService.Get(ctx, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var target Organization
+	if err := decodeJSON(r, &target); err != nil {
+		writeError(w, err)
+		return
+	}
+	if target.ID == "" {
+		writeError(w, newError(ErrUnprocessable, "id is required", nil))
+		return
+	}
+	if target.Name == "" {
+		target.Name = source.Name + " (copy)"
+	}
+	if err := target.validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	status := target.Status
+	if status == "" {
+		status = StatusActive
+	}
+	if err := checkStatusTransition(StatusProvisioning, status); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sourceConfig, err := parseTenantConfig(source.ID, source.Config)
+	if err != nil {
+		writeError(w, newError(nil, "invalid tenant config", err))
+		return
+	}
+	targetConfig, err := parseTenantConfig(target.ID, target.Config)
+	if errors.Is(err, errTenantNotConfigured) {
+		writeError(w, newError(ErrUnprocessable, "config is required", nil))
+		return
+	}
+	if err != nil {
+		writeError(w, newError(ErrUnprocessable, "invalid tenant config", err))
+		return
+	}
+	if targetConfig.DSN == sourceConfig.DSN {
+		writeError(w, newError(ErrUnprocessable, "clone must use a different database", nil))
+		return
+	}
+	sourceDB, err := getTenantDB(ctx, sourceConfig)
+	if err != nil {
+		writeError(w, tenantDBErr(err))
+		return
+	}
+
+	target, err = organizationService.insert(ctx, target, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	result, err := copyTenant(ctx, sourceDB, target, targetConfig)
+	if err != nil {
+		log.Printf("clone %s to %s: %v", source.ID, target.ID, err)
+		if err := organizationService.Delete(ctx, target.ID); err != nil {
+			log.Printf("clone %s to %s: could not delete the clone: %v", source.ID, target.ID, err)
+		}
+		writeError(w, err)
+		return
+	}
+
+	if status == StatusActive {
+		err = organizationService.activate(ctx, target)
+	} else if status != StatusProvisioning {
+		err = centralDB.WithContext(ctx).Model(&target).Update("status", status).Error
+	}
+	if err != nil {
+		writeError(w, newError(nil, "could not update organization status", err))
+		return
+	}
+	if result.Organization, err = organizationService.Get(ctx, target.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, result)
+}
+
+// copyTenant copies the rows of the source's tenant database into the
+// target's, which must be empty. Should the copy fail, it removes the rows
+// copied so far, so that the database can be cloned into again.
+func copyTenant(ctx context.Context, sourceDB *gorm.DB, target Organization, targetConfig TenantConfig) (CloneResult, error) {
+	result := CloneResult{Organization: target}
+	if _, err := organizationService.provision(ctx, target, false); err != nil {
+		return result, newError(ErrUnavailable, "could not provision the tenant database", err)
+	}
+	targetDB, err := getTenantDB(ctx, targetConfig)
+	if err != nil {
+		return result, tenantDBErr(err)
+	}
+
+	for _, model := range clonedModels {
+		var n int64
+		if err := targetDB.Model(model).Unscoped().Count(&n).Error; err != nil {
+			return result, newError(nil, "could not check the tenant database", err)
+		}
+		if n > 0 {
+			return result, newError(ErrConflict, "the tenant database of the clone is not empty", nil)
+		}
+	}
+
+	// Deleted kindergartens still have classrooms, and may be restored.
+	if result.Kindergartens, err = copyRows[Kindergarten](sourceDB.Unscoped(), targetDB, target.ID); err != nil {
+		removeClonedRows(targetDB, target.ID)
+		return result, newError(nil, "could not copy kindergartens", err)
+	}
+	if result.Classrooms, err = copyRows[Classroom](sourceDB, targetDB, target.ID); err != nil {
+		removeClonedRows(targetDB, target.ID)
+		return result, newError(nil, "could not copy classrooms", err)
+	}
+	if result.Settings, err = copyRows[Setting](sourceDB, targetDB, target.ID); err != nil {
+		removeClonedRows(targetDB, target.ID)
+		return result, newError(nil, "could not copy settings", err)
+	}
+	return result, nil
+}
+
+// clonedModels are the models copyTenant copies, children first. Users
+// live in the central database, not in the tenant's, and aren't cloned.
+var clonedModels = []interface{}{&Classroom{}, &Kindergarten{}, &Setting{}}
+
+// removeClonedRows deletes every row copyTenant may have copied into db.
+func removeClonedRows(db *gorm.DB, tenantID string) {
+	db = db.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped()
+	for _, model := range clonedModels {
+		if err := db.Delete(model).Error; err != nil {
+			log.Printf("clone %s: could not remove copied rows: %v", tenantID, err)
+		}
+	}
+}
+
+// copyRows streams every T row from src to dst in batches of cloneBatchSize,
+// each inserted in its own transaction, and returns the number copied.
+func copyRows[T any](src, dst *gorm.DB, tenantID string) (int64, error) {
+	var copied int64
+	var batch []T
+	err := src.FindInBatches(&batch, cloneBatchSize, func(tx *gorm.DB, n int) error {
+		if err := dst.Transaction(func(dtx *gorm.DB) error {
+			return dtx.Create(&batch).Error
+		}); err != nil {
+			return err
+		}
+		copied += tx.RowsAffected
+		log.Printf("clone %s: %s batch %d, %d rows copied", tenantID, tx.Statement.Table, n, copied)
+		return nil
+	}).Error
+	return copied, err
+}
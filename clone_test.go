@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCloneTenant(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	rec := s.tenant("acme", "POST", "/kindergartens", Kindergarten{Name: "Sunflower"})
+	wantStatus(t, rec, http.StatusCreated)
+	k := decode[Kindergarten](t, rec)
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens/"+k.ID+"/classrooms", Classroom{Name: "A"}), http.StatusCreated)
+	wantStatus(t, s.tenant("acme", "PUT", "/settings/theme", `"dark"`), http.StatusCreated)
+
+	rec = s.admin("POST", "/admin/tenants/acme/clone", Organization{ID: "copy", Config: s.tenantConfig("copy")})
+	wantStatus(t, rec, http.StatusCreated)
+	result := decode[CloneResult](t, rec)
+	// The two seed kindergartens are copied too.
+	if result.Kindergartens != 3 || result.Classrooms != 1 || result.Settings != 1 {
+		t.Errorf("copied %+v, want 3 kindergartens, 1 classroom and 1 setting", result)
+	}
+	// Users are central, and not part of a tenant's data.
+	if strings.Contains(rec.Body.String(), `"users"`) {
+		t.Errorf("clone reports users: %s", rec.Body)
+	}
+	if result.Organization.Status != StatusActive || result.Organization.Name != "Organization acme (copy)" {
+		t.Errorf("clone is %+v", result.Organization)
+	}
+	rec = s.tenant("copy", "GET", "/kindergartens/"+k.ID, nil)
+	wantStatus(t, rec, http.StatusOK)
+	if got := decode[Kindergarten](t, rec); got.Name != "Sunflower" {
+		t.Errorf("cloned kindergarten = %+v", got)
+	}
+}
+
+func TestCloneTenantStatus(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+
+	// Clones start out provisioning, which can't move to suspended.
+	clone := Organization{ID: "copy", Config: s.tenantConfig("copy"), Status: StatusSuspended}
+	wantStatus(t, s.admin("POST", "/admin/tenants/acme/clone", clone), http.StatusUnprocessableEntity)
+	wantStatus(t, s.do("GET", "/organizations/copy", nil), http.StatusNotFound)
+
+	clone.Status = StatusArchived
+	rec := s.admin("POST", "/admin/tenants/acme/clone", clone)
+	wantStatus(t, rec, http.StatusCreated)
+	if got := decode[CloneResult](t, rec).Organization.Status; got != StatusArchived {
+		t.Errorf("status = %s, want %s", got, StatusArchived)
+	}
+}
+
+func TestCloneTenantFailure(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	s.createTenant("beta")
+	wantStatus(t, s.tenant("beta", "POST", "/kindergartens", Kindergarten{Name: "Daisy"}), http.StatusCreated)
+
+	// Cloning into a database that has data fails, and leaves it alone.
+	clone := Organization{ID: "copy", Config: s.tenantConfig("beta")}
+	wantStatus(t, s.admin("POST", "/admin/tenants/acme/clone", clone), http.StatusConflict)
+	wantStatus(t, s.do("GET", "/organizations/copy", nil), http.StatusNotFound)
+	var n int64
+	s.tenantDB("beta").Model(&Kindergarten{}).Count(&n)
+	if n != 3 {
+		t.Errorf("beta has %d kindergartens, want 3", n)
+	}
+
+	// The ID of an existing organization.
+	clone = Organization{ID: "beta", Config: s.tenantConfig("other")}
+	wantStatus(t, s.admin("POST", "/admin/tenants/acme/clone", clone), http.StatusConflict)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// tenantInFlight counts the requests each tenant has in progress.
+var tenantInFlight = struct {
+	sync.Mutex
+	m map[string]int
+}{m: map[string]int{}}
+
+// acquireTenantSlot counts a request of tenant in, unless it already has
+// limit requests in progress. It reports whether it did; the caller then
+// releases the slot with releaseTenantSlot.
+func acquireTenantSlot(tenant string, limit int) bool {
+	tenantInFlight.Lock()
+	defer tenantInFlight.Unlock()
+	if tenantInFlight.m[tenant] >= limit {
+		return false
+	}
+	tenantInFlight.m[tenant]++
+	return true
+}
+
+func releaseTenantSlot(tenant string) {
+	tenantInFlight.Lock()
+	defer tenantInFlight.Unlock()
+	if tenantInFlight.m[tenant]--; tenantInFlight.m[tenant] <= 0 {
+		delete(tenantInFlight.m, tenant)
+	}
+}
+
+// maxInFlight returns the number of requests the tenant may have in
+// progress at once: its own TenantConfig.MaxInFlight, or else
+// Config.TenantMaxInFlight. Zero means no limit.
+func (tc TenantConfig) maxInFlight() int {
+	if tc.MaxInFlight > 0 {
+		return tc.MaxInFlight
+	}
+	return cfg().TenantMaxInFlight
+}
+
+// LimitTenantConcurrency refuses requests with 429 while their tenant has
+// as many requests in progress as it may, so that one tenant can't tie up
+// the database connections others share. It must run after
+// TenantMiddleware.
+func LimitTenantConcurrency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := tenantConfig(r.Context()).maxInFlight()
+		tenant := tenantID(r.Context())
+		if limit <= 0 || tenant == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !acquireTenantSlot(tenant, limit) {
+			w.Header().Set("Retry-After", "1")
+			httpError(w, "too many concurrent requests", http.StatusTooManyRequests)
+			return
+		}
+		defer releaseTenantSlot(tenant)
+		next.ServeHTTP(w, r)
+	})
+}
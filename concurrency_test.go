@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestLimitTenantConcurrency(t *testing.T) {
+	s := newTestServer(t)
+	config, _ := json.Marshal(map[string]interface{}{"dsn": s.tenantDSN("acme"), "max_in_flight": 3})
+	wantStatus(t, s.do("POST", "/organizations", Organization{ID: "acme", Name: "Acme", Config: string(config)}), http.StatusOK)
+	s.createTenant("beta")
+
+	started, release := make(chan struct{}), make(chan struct{})
+	hold := true
+	handler := TenantMiddleware(LimitTenantConcurrency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hold {
+			started <- struct{}{}
+			<-release
+		}
+	})))
+	serve := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Tenant-ID", tenant)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rec := serve("acme"); rec.Code != http.StatusOK {
+				t.Errorf("held request: status = %d", rec.Code)
+			}
+		}()
+		<-started
+	}
+	hold = false
+	for i := 0; i < 2; i++ {
+		rec := serve("acme")
+		wantStatus(t, rec, http.StatusTooManyRequests)
+		if got := rec.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("Retry-After = %q, want 1", got)
+		}
+	}
+	// Other tenants have slots of their own, and no limit by default.
+	wantStatus(t, serve("beta"), http.StatusOK)
+
+	close(release)
+	wg.Wait()
+	wantStatus(t, serve("acme"), http.StatusOK)
+	tenantInFlight.Lock()
+	defer tenantInFlight.Unlock()
+	if len(tenantInFlight.m) != 0 {
+		t.Errorf("in flight = %v after every request finished", tenantInFlight.m)
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config holds the runtime settings. They are read from the environment
+// and from the optional CONFIG_FILE of KEY=VALUE lines, whose values take
+// precedence so that editing the file and sending SIGHUP changes them.
+// Settings marked restart-only keep their startup value on reload.
+type Config struct {
+	// LogLevel is the minimum level of the application log.
+	LogLevel slog.Level
+
+	// Debug exposes diagnostics that production shouldn't, such as the
+	// route listing of GET /.
+	Debug bool
+
+	// RedactQueryLogs leaves the parameters out of logged queries. Turn it
+	// off to see them when debugging locally.
+	RedactQueryLogs bool
+
+	// CentralDSN locates the central database, which holds organizations.
+	// Its scheme selects the driver, see openDialector. Restart-only.
+	CentralDSN string
+
+	// TenantDSNTemplate is the DSN of tenants whose config has none, a
+	// text/template given the organization ID as {{.TenantID}}, such as
+	// "postgres://app:secret@db/app?search_path={{.TenantID}}".
+	TenantDSNTemplate string
+
+	// AllowedTenantDrivers lists the drivers tenant databases may use, by
+	// their gorm name ("sqlite", "postgres", "mysql"); empty allows all.
+	AllowedTenantDrivers []string
+
+	// AutoMigrate migrates a tenant database the first time it is opened.
+	AutoMigrate bool
+
+	// MigrateTenantsOnStartup migrates every tenant database before the
+	// server starts. Restart-only.
+	MigrateTenantsOnStartup bool
+
+	// AllowNewerTenantSchema lets requests reach tenant databases with
+	// migrations this version doesn't know, only logging them, instead of
+	// refusing them with 503. See checkTenantSchemaNewer.
+	AllowNewerTenantSchema bool
+
+	// AsyncProvisioning makes creating an organization only queue the
+	// provisioning of its tenant database, see CreateAsync.
+	AsyncProvisioning bool
+
+	// TenantOpenTimeout bounds how long opening a tenant database may take.
+	TenantOpenTimeout time.Duration
+
+	// TenantMaxConcurrentOpens bounds how many tenant databases are opened
+	// at the same time; zero means no bound. Cached databases don't count.
+	// Restart-only.
+	TenantMaxConcurrentOpens int
+
+	// WebhookAllowPrivateTargets lets tenants' webhooks reach loopback,
+	// private and link-local addresses, which are refused by default, see
+	// webhookTargetAllowed. Turn it on for webhooks within a private
+	// network.
+	WebhookAllowPrivateTargets bool
+	// TenantKeepAliveInterval is how often cached tenant databases other
+	// than SQLite are pinged, see pingTenantDBs. Zero turns the pings off.
+	TenantKeepAliveInterval time.Duration
+
+	// PurgeInterval is how often soft-deleted rows past their retention
+	// are purged from the tenant databases, see purgeTenants. Zero turns
+	// purging off. KindergartenRetention is how long deleted kindergartens
+	// are kept; zero keeps them for good.
+	PurgeInterval         time.Duration
+	KindergartenRetention time.Duration
+
+	// CORSAllowedOrigins lists the origins allowed outside of tenants that
+	// configure their own, and CORSMaxAge how long browsers may cache a
+	// preflight response.
+	CORSAllowedOrigins []string
+	CORSMaxAge         time.Duration
+
+	// TrustedProxies are the networks whose forwarding headers RealIP
+	// believes.
+	TrustedProxies []*net.IPNet
+
+	// RateLimitRPS and RateLimitBurst set the request rate allowed per
+	// tenant: a burst of up to RateLimitBurst requests, refilled at
+	// RateLimitRPS per second. The limit is off while either is zero.
+	RateLimitRPS   float64
+	RateLimitBurst int
+
+	// IPRateLimitRPS and IPRateLimitBurst do the same per client IP for
+	// the routes outside of tenants, such as the admin API and health
+	// checks.
+	IPRateLimitRPS   float64
+	IPRateLimitBurst int
+
+	// RateLimitBackend keeps the buckets in "memory", limiting each
+	// instance on its own, or in the central "database", limiting all
+	// instances together. RateLimitFailOpen lets requests through when the
+	// database can't be reached, rather than refusing them.
+	RateLimitBackend  string
+	RateLimitFailOpen bool
+
+	// TenantMaxInFlight bounds the requests a tenant can have in progress
+	// at once, unless its config sets its own bound; zero means no bound.
+	TenantMaxInFlight int
+
+	// ResponseCacheTTL is how long the responses of tenant reads are
+	// served from the cache, see CacheTenantReads. Zero turns caching off.
+	ResponseCacheTTL time.Duration
+
+	// CaseInsensitiveOrgNames makes organization names differing only in
+	// case count as the same name. Names saved before it changed keep the
+	// form they were saved in until they are saved again.
+	CaseInsensitiveOrgNames bool
+
+	// UsernameMinLength and UsernameMaxLength bound the length of
+	// usernames, and NameMinLength and NameMaxLength that of organization
+	// and kindergarten names, in characters. A zero maximum means no bound.
+	UsernameMinLength int
+	UsernameMaxLength int
+	NameMinLength     int
+	NameMaxLength     int
+
+	// PasswordHashCost is the bcrypt cost new passwords are hashed at.
+	// Raising it rehashes existing passwords as their users log in.
+	PasswordHashCost int
+
+	// BreakerThreshold is the number of consecutive failures to open a
+	// tenant database after which its circuit breaker opens, and
+	// BreakerCooldown how long it stays open. A zero threshold disables
+	// the breakers.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// AdminToken is the bearer token required by the /admin routes. The
+	// admin API is disabled when it is empty.
+	AdminToken string
+
+	// RequireAPIKey refuses tenant requests that name their tenant with
+	// X-Tenant-ID alone, without an API key, so that every request is
+	// held to the scopes of its key, see RequireScope.
+	RequireAPIKey bool
+
+	// StatsConcurrency bounds how many tenant databases /admin/stats
+	// queries at once, and StatsCacheTTL how long its result, and that of
+	// /admin/tenants/{id}/usage, is reused.
+	StatsConcurrency int
+	StatsCacheTTL    time.Duration
+
+	// TenantUsageTimeout bounds how long measuring a tenant's usage takes.
+	TenantUsageTimeout time.Duration
+
+	// ResponseEnvelope wraps every response body: {"data": ...} for
+	// objects, {"data": [...], "meta": {...}} for lists in every API
+	// version, and {"error": "..."} for errors.
+	ResponseEnvelope bool
+
+	// UnpaginatedLimit is the limit of list requests that don't ask for
+	// one; zero means no limit. Responses flag when there are more rows.
+	UnpaginatedLimit int
+
+	// MaxPageSize caps the rows a list returns, whatever limit is asked
+	// for; zero means no cap. Larger limits are lowered to it, or rejected
+	// when RejectOversizedPages is set.
+	MaxPageSize          int
+	RejectOversizedPages bool
+
+	// RejectImmutableChanges refuses updates changing the immutableFields
+	// of a model with 422, instead of ignoring those changes.
+	RejectImmutableChanges bool
+
+	// SettingMaxValueBytes bounds the size of a tenant setting's value,
+	// and SettingMaxKeys the number of settings a tenant has; zero means
+	// no bound on the number.
+	SettingMaxValueBytes int
+	SettingMaxKeys       int
+
+	// JSONMaxDepth bounds the nesting of JSON request bodies, and
+	// JSONMaxTokens the number of tokens (values, keys and brackets) in
+	// them; zero means no bound.
+	JSONMaxDepth  int
+	JSONMaxTokens int
+
+	// BatchGetMaxIDs is the most organizations POST
+	// /organizations/batch-get fetches at once.
+	BatchGetMaxIDs int
+
+	// UserImportMaxSize is the most users POST /users/batch imports at
+	// once.
+	UserImportMaxSize int
+
+	// MetricsTenants are the tenants counted on their own in the
+	// per-tenant metrics; the others share a single "other" label.
+	MetricsTenants []string
+
+	// QueryCountThreshold is the number of SQL statements a request may
+	// run before it is logged as a likely N+1; zero turns the check off.
+	QueryCountThreshold int
+
+	// ShutdownGracePeriod is how long in-flight requests and background
+	// work may run after SIGINT or SIGTERM before they are cut off.
+	ShutdownGracePeriod time.Duration
+}
+
+var (
+	currentConfig atomic.Pointer[Config]
+
+	// logLevel is the level of the default slog handler, kept in sync
+	// with Config.LogLevel.
+	logLevel slog.LevelVar
+)
+
+// cfg returns the configuration in effect. Code needing several settings
+// to agree with each other should hold on to the returned value.
+func cfg() *Config {
+	return currentConfig.Load()
+}
+
+func setConfig(c *Config) {
+	logLevel.Set(c.LogLevel)
+	currentConfig.Store(c)
+}
+
+func loadConfig() *Config {
+	e := loadEnv(os.Getenv("CONFIG_FILE"))
+	return &Config{
+		LogLevel:                   e.level("LOG_LEVEL", slog.LevelInfo),
+		Debug:                      e.bool("DEBUG", false),
+		RedactQueryLogs:            e.bool("REDACT_QUERY_LOGS", true),
+		CentralDSN:                 e.string("CENTRAL_DSN", "central.db"),
+		TenantDSNTemplate:          e.string("TENANT_DSN_TEMPLATE", ""),
+		AllowedTenantDrivers:       e.list("TENANT_ALLOWED_DRIVERS"),
+		AutoMigrate:                e.bool("TENANT_AUTO_MIGRATE", true),
+		MigrateTenantsOnStartup:    e.bool("MIGRATE_TENANTS_ON_STARTUP", false),
+		AllowNewerTenantSchema:     e.bool("ALLOW_NEWER_TENANT_SCHEMA", false),
+		AsyncProvisioning:          e.bool("ASYNC_PROVISIONING", false),
+		TenantOpenTimeout:          e.duration("TENANT_OPEN_TIMEOUT", 5*time.Second),
+		TenantMaxConcurrentOpens:   e.int("TENANT_MAX_CONCURRENT_OPENS", 8),
+		WebhookAllowPrivateTargets: e.bool("WEBHOOK_ALLOW_PRIVATE_TARGETS", false),
+		TenantKeepAliveInterval:    e.duration("TENANT_KEEPALIVE_INTERVAL", time.Minute),
+		PurgeInterval:              e.duration("PURGE_INTERVAL", time.Hour),
+		KindergartenRetention:      e.duration("KINDERGARTEN_RETENTION", 0),
+		CORSAllowedOrigins:         e.list("CORS_ALLOWED_ORIGINS"),
+		CORSMaxAge:                 e.duration("CORS_MAX_AGE", 10*time.Minute),
+		TrustedProxies:             e.cidrs("TRUSTED_PROXIES"),
+		RateLimitRPS:               e.float("RATE_LIMIT_RPS", 0),
+		RateLimitBurst:             e.int("RATE_LIMIT_BURST", 0),
+		IPRateLimitRPS:             e.float("IP_RATE_LIMIT_RPS", 0),
+		IPRateLimitBurst:           e.int("IP_RATE_LIMIT_BURST", 0),
+		CaseInsensitiveOrgNames:    e.bool("ORGANIZATION_NAMES_CASE_INSENSITIVE", true),
+		UsernameMinLength:          e.int("USERNAME_MIN_LENGTH", 3),
+		UsernameMaxLength:          e.int("USERNAME_MAX_LENGTH", 64),
+		NameMinLength:              e.int("NAME_MIN_LENGTH", 1),
+		NameMaxLength:              e.int("NAME_MAX_LENGTH", 200),
+		PasswordHashCost:           e.int("PASSWORD_HASH_COST", bcrypt.DefaultCost),
+		BreakerThreshold:           e.int("TENANT_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:            e.duration("TENANT_BREAKER_COOLDOWN", 30*time.Second),
+		RateLimitBackend:           e.string("RATE_LIMIT_BACKEND", "memory"),
+		RateLimitFailOpen:          e.bool("RATE_LIMIT_FAIL_OPEN", true),
+		TenantMaxInFlight:          e.int("TENANT_MAX_IN_FLIGHT", 0),
+		ResponseCacheTTL:           e.duration("RESPONSE_CACHE_TTL", 0),
+		AdminToken:                 e.string("ADMIN_TOKEN", ""),
+		RequireAPIKey:              e.bool("REQUIRE_API_KEY", false),
+		StatsConcurrency:           e.int("STATS_CONCURRENCY", 4),
+		StatsCacheTTL:              e.duration("STATS_CACHE_TTL", 30*time.Second),
+		TenantUsageTimeout:         e.duration("TENANT_USAGE_TIMEOUT", 10*time.Second),
+		ResponseEnvelope:           e.bool("RESPONSE_ENVELOPE", false),
+		UnpaginatedLimit:           e.int("UNPAGINATED_LIMIT", 100),
+		MaxPageSize:                e.int("MAX_PAGE_SIZE", 1000),
+		RejectOversizedPages:       e.bool("REJECT_OVERSIZED_PAGES", false),
+		RejectImmutableChanges:     e.bool("REJECT_IMMUTABLE_CHANGES", false),
+		BatchGetMaxIDs:             e.int("BATCH_GET_MAX_IDS", 100),
+		UserImportMaxSize:          e.int("USER_IMPORT_MAX_SIZE", 100),
+		SettingMaxValueBytes:       e.int("SETTING_MAX_VALUE_BYTES", 64<<10),
+		SettingMaxKeys:             e.int("SETTING_MAX_KEYS", 100),
+		JSONMaxDepth:               e.int("JSON_MAX_DEPTH", 32),
+		JSONMaxTokens:              e.int("JSON_MAX_TOKENS", 10000),
+		ShutdownGracePeriod:        e.duration("SHUTDOWN_GRACE_PERIOD", 15*time.Second),
+		QueryCountThreshold:        e.int("QUERY_COUNT_THRESHOLD", 0),
+		MetricsTenants:             e.list("METRICS_TENANTS"),
+	}
+}
+
+// reloadConfig loads the configuration again and swaps it in, keeping the
+// current value of restart-only settings.
+func reloadConfig() {
+	current, next := cfg(), loadConfig()
+	if next.CentralDSN != current.CentralDSN {
+		log.Printf("CENTRAL_DSN only changes on restart, keeping %s", redactDSN(current.CentralDSN, current.CentralDSN))
+		next.CentralDSN = current.CentralDSN
+	}
+	if next.MigrateTenantsOnStartup != current.MigrateTenantsOnStartup {
+		log.Printf("MIGRATE_TENANTS_ON_STARTUP only changes on restart, keeping %t", current.MigrateTenantsOnStartup)
+		next.MigrateTenantsOnStartup = current.MigrateTenantsOnStartup
+	}
+	if next.TenantMaxConcurrentOpens != current.TenantMaxConcurrentOpens {
+		log.Printf("TENANT_MAX_CONCURRENT_OPENS only changes on restart, keeping %d", current.TenantMaxConcurrentOpens)
+		next.TenantMaxConcurrentOpens = current.TenantMaxConcurrentOpens
+	}
+	setConfig(next)
+	log.Printf("configuration reloaded")
+}
+
+// reloadConfigOnSIGHUP reloads the configuration whenever the process
+// receives SIGHUP.
+func reloadConfigOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig()
+		}
+	}()
+}
+
+// env looks settings up in the config file first and in the environment
+// second.
+type env map[string]string
+
+func loadEnv(path string) env {
+	e := env{}
+	if path == "" {
+		return e
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("could not read config file: %v", err)
+		return e
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			log.Printf("invalid config file line %q, ignoring it", line)
+			continue
+		}
+		e[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return e
+}
+
+func (e env) lookup(key string) (string, bool) {
+	if v, ok := e[key]; ok {
+		return v, true
+	}
+	return os.LookupEnv(key)
+}
+
+func (e env) string(key, def string) string {
+	v, ok := e.lookup(key)
+	if !ok || v == "" {
+		return def
+	}
+	return v
+}
+
+func (e env) bool(key string, def bool) bool {
+	v, ok := e.lookup(key)
+	if !ok || v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %v", key, v, def)
+		return def
+	}
+	return b
+}
+
+func (e env) int(key string, def int) int {
+	v, ok := e.lookup(key)
+	if !ok || v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+func (e env) float(key string, def float64) float64 {
+	v, ok := e.lookup(key)
+	if !ok || v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %v", key, v, def)
+		return def
+	}
+	return f
+}
+
+func (e env) duration(key string, def time.Duration) time.Duration {
+	v, ok := e.lookup(key)
+	if !ok || v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+func (e env) level(key string, def slog.Level) slog.Level {
+	v, ok := e.lookup(key)
+	if !ok || v == "" {
+		return def
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(v)); err != nil {
+		log.Printf("invalid %s=%q, using %s", key, v, def)
+		return def
+	}
+	return l
+}
+
+// list reads a comma-separated list.
+func (e env) list(key string) []string {
+	v, _ := e.lookup(key)
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// cidrs reads a comma-separated list of CIDRs; bare IPs stand for
+// themselves.
+func (e env) cidrs(key string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, v := range e.list(key) {
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			log.Printf("invalid %s entry %q, ignoring it", key, v)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	s := newTestServer(t)
+	before := *cfg()
+	path := filepath.Join(s.dir, "config")
+	file := "LOG_LEVEL=debug\nCENTRAL_DSN=elsewhere.db\nMIGRATE_TENANTS_ON_STARTUP=true\nTENANT_MAX_CONCURRENT_OPENS=99\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+
+	reloadConfig()
+	c := cfg()
+	if c.LogLevel != slog.LevelDebug {
+		t.Errorf("log level = %s, want %s", c.LogLevel, slog.LevelDebug)
+	}
+	// Restart-only.
+	if c.CentralDSN != before.CentralDSN {
+		t.Errorf("central DSN = %s, want %s", c.CentralDSN, before.CentralDSN)
+	}
+	if c.MigrateTenantsOnStartup != before.MigrateTenantsOnStartup {
+		t.Errorf("migrate tenants on startup = %t, want %t", c.MigrateTenantsOnStartup, before.MigrateTenantsOnStartup)
+	}
+	if c.TenantMaxConcurrentOpens != before.TenantMaxConcurrentOpens {
+		t.Errorf("tenant max concurrent opens = %d, want %d", c.TenantMaxConcurrentOpens, before.TenantMaxConcurrentOpens)
+	}
+}
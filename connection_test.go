@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTestConnection(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.TenantOpenTimeout = 200 * time.Millisecond })
+	good := filepath.Join(s.dir, "good.db")
+	if err := os.WriteFile(good, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// A port nothing listens on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+	missing := filepath.Join(s.dir, "missing.db")
+	hung, _ := hungDatabase(t)
+
+	for _, tt := range []struct {
+		name string
+		dsn  string
+		ok   bool
+	}{
+		{"good", good, true},
+		{"missing file", missing, false},
+		{"refused", "postgres://app:hunter2@" + closed + "/app?sslmode=disable", false},
+		{"hung", "postgres://app:hunter2@" + hung + "/app?sslmode=disable", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _ := json.Marshal(map[string]string{"dsn": tt.dsn})
+			start := time.Now()
+			rec := s.admin("POST", "/admin/tenants/test-connection", ConnectionTest{Config: string(config)})
+			wantStatus(t, rec, http.StatusOK)
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("took %v", elapsed)
+			}
+			got := decode[ConnectionTestResult](t, rec)
+			if got.OK != tt.ok || got.OK != (got.Error == "") {
+				t.Errorf("result = %+v, want ok %v", got, tt.ok)
+			}
+			if strings.Contains(got.Error, "hunter2") {
+				t.Errorf("error %q has the password", got.Error)
+			}
+		})
+	}
+
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("testing the connection created %s", missing)
+	}
+	tenantDBs.Lock()
+	if n := len(tenantDBs.m); n != 0 {
+		t.Errorf("%d tenant databases kept open", n)
+	}
+	tenantDBs.Unlock()
+	wantStatus(t, s.admin("POST", "/admin/tenants/test-connection", ConnectionTest{Config: "{}"}), http.StatusUnprocessableEntity)
+}
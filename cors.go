@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	corsAllowedMethods = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowedHeaders = "Authorization, Content-Type, Accept, X-Tenant-ID"
+
+	// tenantOriginsTTL is how long tenantOrigins is kept before it is read
+	// again, to pick up the organizations written by other instances.
+	tenantOriginsTTL = time.Minute
+)
+
+// tenantOrigins caches the origins allowed by any tenant, so that
+// preflights don't read every organization. Organization writes reset it.
+var tenantOrigins struct {
+	sync.Mutex
+	origins map[string]bool
+	expires time.Time
+}
+
+// CORS answers preflight requests and allows the globally configured
+// origins. Tenant routes narrow this down further in TenantMiddleware, once
+// the tenant is known.
+//
+// Browsers never send custom headers such as X-Tenant-ID on a preflight, so
+// a preflight is allowed for the global origins and for any origin allowed
+// by at least one tenant; the actual request is then checked against its
+// own tenant.
+func CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !originAllowed(cfg().CORSAllowedOrigins, origin) && !anyTenantAllowsOrigin(r.Context(), origin) {
+				httpError(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			if cfg().CORSMaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg().CORSMaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if originAllowed(cfg().CORSAllowedOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// applyTenantCORS replaces the global origin decision with the tenant's own
+// list, when it has one.
+func applyTenantCORS(w http.ResponseWriter, r *http.Request, tc TenantConfig) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || tc.AllowedOrigins == nil {
+		return
+	}
+	if originAllowed(tc.AllowedOrigins, origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	} else {
+		w.Header().Del("Access-Control-Allow-Origin")
+	}
+}
+
+func originAllowed(allowed []string, origin string) bool {
+	return slices.Contains(allowed, "*") || slices.Contains(allowed, origin)
+}
+
+func anyTenantAllowsOrigin(ctx context.Context, origin string) bool {
+	tenantOrigins.Lock()
+	defer tenantOrigins.Unlock()
+	if tenantOrigins.origins == nil || time.Now().After(tenantOrigins.expires) {
+		var organizations []Organization
+		if err := centralDB.WithContext(ctx).Select("id", "config").Find(&organizations).Error; err != nil {
+			return false
+		}
+		origins := map[string]bool{}
+		for _, org := range organizations {
+			if tc, err := parseTenantConfig(org.ID, org.Config); err == nil {
+				for _, o := range tc.AllowedOrigins {
+					origins[o] = true
+				}
+			}
+		}
+		tenantOrigins.origins = origins
+		tenantOrigins.expires = time.Now().Add(tenantOriginsTTL)
+	}
+	return tenantOrigins.origins["*"] || tenantOrigins.origins[origin]
+}
+
+func resetTenantOrigins() {
+	tenantOrigins.Lock()
+	defer tenantOrigins.Unlock()
+	tenantOrigins.origins = nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestTenantCORS(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		c.CORSAllowedOrigins = []string{"https://admin.example.com"}
+		c.CORSMaxAge = 10 * time.Minute
+	})
+	config, _ := json.Marshal(map[string]interface{}{"dsn": s.tenantDSN("acme"), "allowed_origins": []string{"https://acme.example.com"}})
+	wantStatus(t, s.do("POST", "/organizations", Organization{ID: "acme", Name: "Acme", Config: string(config)}), http.StatusOK)
+
+	for _, tt := range []struct {
+		origin string
+		allow  bool
+	}{
+		{"https://acme.example.com", true},
+		// The tenant's list replaces the global one.
+		{"https://admin.example.com", false},
+		{"https://evil.example.com", false},
+	} {
+		rec := s.tenant("acme", "GET", "/kindergartens", nil, "Origin", tt.origin)
+		wantStatus(t, rec, http.StatusOK)
+		if got := rec.Header().Get("Access-Control-Allow-Origin") == tt.origin; got != tt.allow {
+			t.Errorf("%s: allowed = %v, want %v", tt.origin, got, tt.allow)
+		}
+	}
+
+	// Preflights carry no X-Tenant-ID, and pass for any tenant's origin.
+	for _, tt := range []struct {
+		origin string
+		status int
+	}{
+		{"https://acme.example.com", http.StatusNoContent},
+		{"https://admin.example.com", http.StatusNoContent},
+		{"https://evil.example.com", http.StatusForbidden},
+	} {
+		rec := s.do("OPTIONS", "/kindergartens", nil, "Origin", tt.origin, "Access-Control-Request-Method", "GET")
+		wantStatus(t, rec, tt.status)
+		if tt.status == http.StatusNoContent && rec.Header().Get("Access-Control-Max-Age") != "600" {
+			t.Errorf("%s: Access-Control-Max-Age = %q, want 600", tt.origin, rec.Header().Get("Access-Control-Max-Age"))
+		}
+	}
+}
+
+func TestTenantCORSOriginsCached(t *testing.T) {
+	s := newTestServer(t)
+	preflight := func(origin string) int {
+		return s.do("OPTIONS", "/kindergartens", nil, "Origin", origin, "Access-Control-Request-Method", "GET").Code
+	}
+	queries := 0
+	centralDB.Callback().Query().Before("gorm:query").Register("test:count", func(*gorm.DB) { queries++ })
+	t.Cleanup(func() { centralDB.Callback().Query().Remove("test:count") })
+
+	for range 3 {
+		if got := preflight("https://acme.example.com"); got != http.StatusForbidden {
+			t.Fatalf("preflight = %d, want %d", got, http.StatusForbidden)
+		}
+	}
+	if queries != 1 {
+		t.Errorf("%d queries for 3 preflights, want 1", queries)
+	}
+
+	// Creating an organization drops the cached origins.
+	config, _ := json.Marshal(map[string]interface{}{"dsn": s.tenantDSN("acme"), "allowed_origins": []string{"https://acme.example.com"}})
+	wantStatus(t, s.do("POST", "/organizations", Organization{ID: "acme", Name: "Acme", Config: string(config)}), http.StatusOK)
+	if got := preflight("https://acme.example.com"); got != http.StatusNoContent {
+		t.Errorf("preflight after create = %d, want %d", got, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateRespondsWithStoredRecord(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+
+	// sameAsGet fails unless the GET of path answers what created did.
+	sameAsGet := func(name string, created *httptest.ResponseRecorder, get func(path string) *httptest.ResponseRecorder, path string) {
+		t.Helper()
+		got := get(path)
+		wantStatus(t, got, http.StatusOK)
+		if created.Body.String() != got.Body.String() {
+			t.Errorf("%s: created %s, GET gives %s", name, strings.TrimSpace(created.Body.String()), strings.TrimSpace(got.Body.String()))
+		}
+		if created.Header().Get("ETag") != got.Header().Get("ETag") {
+			t.Errorf("%s: ETag %q on create, %q on GET", name, created.Header().Get("ETag"), got.Header().Get("ETag"))
+		}
+	}
+	central := func(path string) *httptest.ResponseRecorder { return s.do("GET", path, nil) }
+	tenant := func(path string) *httptest.ResponseRecorder { return s.tenant("acme", "GET", path, nil) }
+
+	rec := s.do("POST", "/organizations", Organization{ID: "beta", Name: "Beta", Config: s.tenantConfig("beta"), Status: StatusSuspended})
+	wantStatus(t, rec, http.StatusOK)
+	sameAsGet("organization", rec, central, "/organizations/beta")
+
+	rec = s.do("POST", "/users", map[string]string{"username": "Erin", "password": "secret"})
+	wantStatus(t, rec, http.StatusOK)
+	if strings.Contains(rec.Body.String(), "secret") || strings.Contains(rec.Body.String(), "password") {
+		t.Errorf("created user %s shows the password", rec.Body.String())
+	}
+	sameAsGet("user", rec, central, fmt.Sprint("/users/", decode[User](t, rec).ID))
+
+	rec = s.tenant("acme", "POST", "/kindergartens", Kindergarten{ID: "k1", Name: "Sunflower"})
+	wantStatus(t, rec, http.StatusCreated)
+	sameAsGet("kindergarten", rec, tenant, "/kindergartens/k1")
+
+	rec = s.tenant("acme", "POST", "/kindergartens/k1/classrooms", Classroom{ID: 99, Name: "Daisies"})
+	wantStatus(t, rec, http.StatusCreated)
+	sameAsGet("classroom", rec, tenant, fmt.Sprint("/kindergartens/k1/classrooms/", decode[Classroom](t, rec).ID))
+
+	rec = s.tenant("acme", "PUT", "/settings/theme", `{"mode":  "dark"}`)
+	wantStatus(t, rec, http.StatusCreated)
+	sameAsGet("setting", rec, tenant, "/settings/theme")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// decodeJSON decodes the JSON request body into v, after checkJSON has
+// scanned it. Anything following the first value is ignored, as
+// json.Decoder does.
+//
+// Bodies that can't be read as JSON fail with ErrBadRequest, for 400, and
+// JSON values of the wrong type with ErrUnprocessable, for 422, like the
+// validate methods checking the decoded values.
+func decodeJSON(r *http.Request, v interface{}) error {
+	if err := checkContentType(r); err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := checkJSON(io.TeeReader(r.Body, &body)); err != nil {
+		return err
+	}
+	err := json.NewDecoder(&body).Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fieldError(typeErr.Field, fmt.Sprintf("must be of type %s, not %s", jsonType(typeErr.Type), typeErr.Value), err)
+	case err != nil:
+		return newError(ErrBadRequest, "invalid input", err)
+	}
+	return nil
+}
+
+// checkContentType fails with ErrBadRequest for request bodies sent as
+// anything but JSON. Bodies without a Content-Type are taken to be JSON.
+func checkContentType(r *http.Request) error {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return newError(ErrBadRequest, "Content-Type must be application/json", err)
+	}
+	return nil
+}
+
+// jsonType names the JSON type a Go value of type t is decoded from, in
+// the words json.UnmarshalTypeError uses for the value it got.
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// checkJSON scans the first JSON value read from rd token by token, and
+// fails with ErrBadRequest once it is nested deeper than
+// Config.JSONMaxDepth or has more than Config.JSONMaxTokens tokens. That
+// way a pathological body is refused before any of it is decoded into a
+// value, at a cost bounded by the limits.
+func checkJSON(rd io.Reader) error {
+	c := cfg()
+	dec := json.NewDecoder(rd)
+	depth, tokens := 0, 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return newError(ErrBadRequest, "invalid input", err)
+		}
+		tokens++
+		if c.JSONMaxTokens > 0 && tokens > c.JSONMaxTokens {
+			return newError(ErrBadRequest, fmt.Sprintf("JSON body has more than %d tokens", c.JSONMaxTokens), nil)
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if c.JSONMaxDepth > 0 && depth > c.JSONMaxDepth {
+				return newError(ErrBadRequest, fmt.Sprintf("JSON body is nested more than %d levels deep", c.JSONMaxDepth), nil)
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
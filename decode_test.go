@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJSONBodyLimits(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		c.JSONMaxDepth = 4
+		c.JSONMaxTokens = 20
+	})
+	s.createTenant("acme")
+
+	for _, tt := range []struct {
+		name, body string
+		status     int
+		message    string
+	}{
+		{"within the limits", `{"name": "K", "classrooms": [{"name": "A"}]}`, http.StatusCreated, ""},
+		{"too deep", `{"name": "K", "x": [[[[1]]]]}`, http.StatusBadRequest, "JSON body is nested more than 4 levels deep"},
+		{"too many tokens", `{"name": "K", "x": [` + strings.Repeat("1,", 20) + `1]}`, http.StatusBadRequest, "JSON body has more than 20 tokens"},
+		{"truncated", `{"name": "K"`, http.StatusBadRequest, "invalid input"},
+		// Only the first value is read.
+		{"trailing data", `{"name": "K"} [[[[[[`, http.StatusCreated, ""},
+	} {
+		rec := s.tenant("acme", "POST", "/kindergartens", tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d; body: %s", tt.name, rec.Code, tt.status, rec.Body.String())
+			continue
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.message != "" && got != tt.message {
+			t.Errorf("%s: error = %q, want %q", tt.name, got, tt.message)
+		}
+	}
+
+	// Settings values are checked too.
+	wantStatus(t, s.tenant("acme", "PUT", "/settings/deep", `[[[[[1]]]]]`), http.StatusBadRequest)
+}
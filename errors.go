@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Kinds of failure handlers report to clients. Wrap them with newError so
+// they carry a message; writeError maps them to a status.
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrBadRequest         = errors.New("bad request")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrUnprocessable      = errors.New("unprocessable")
+	ErrForbidden          = errors.New("forbidden")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrUnavailable        = errors.New("unavailable")
+	ErrTimeout            = errors.New("timeout")
+)
+
+// Error is a failure with a message fit for clients. errors.Is matches both
+// its Kind and its underlying cause.
+type Error struct {
+	Kind    error
+	Message string
+	Err     error
+	// Field is the path of the request field at fault, such as "name" or
+	// "config.migration_hooks[1]", for validation errors.
+	Field string
+}
+
+func newError(kind error, message string, cause error) *Error {
+	return &Error{Kind: kind, Message: message, Err: cause}
+}
+
+// fieldError returns an ErrUnprocessable error about the field at path,
+// whose message starts with the path so that plain text errors name it
+// too.
+func fieldError(path, message string, cause error) *Error {
+	return &Error{Kind: ErrUnprocessable, Message: path + " " + message, Err: cause, Field: path}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// errorStatus maps an error to the HTTP status reporting it.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrUnprocessable):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError reports err to the client. Only the message of an *Error is
+// shown; anything else is logged and hidden behind a generic message. The
+// envelope also carries the Field of validation errors.
+func writeError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("internal error: %v", err)
+	}
+	body := errorEnvelope{Error: errorMessage(err)}
+	var e *Error
+	if errors.As(err, &e) {
+		body.Field = e.Field
+	}
+	writeErrorBody(w, body, status)
+}
+
+// httpError writes an error message as plain text, or as {"error": message}
+// when Config.ResponseEnvelope is set.
+func httpError(w http.ResponseWriter, message string, status int) {
+	writeErrorBody(w, errorEnvelope{Error: message}, status)
+}
+
+// writeErrorBody writes the envelope when Config.ResponseEnvelope is set,
+// and only its message otherwise.
+func writeErrorBody(w http.ResponseWriter, e errorEnvelope, status int) {
+	if !cfg().ResponseEnvelope {
+		http.Error(w, e.Error, status)
+		return
+	}
+	body, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// errorMessage returns the part of err that may be shown to clients.
+func errorMessage(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Message
+	}
+	return http.StatusText(errorStatus(err))
+}
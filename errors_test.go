@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBadRequestAndUnprocessable(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	tooMany := make([]string, cfg().BatchGetMaxIDs+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprint("org", i)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		tenant string
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"invalid JSON", "", "POST", "/organizations", "{", http.StatusBadRequest},
+		{"wrong type", "", "POST", "/organizations", `{"name": 1}`, http.StatusUnprocessableEntity},
+		{"invalid ID", "", "POST", "/organizations", Organization{ID: "no spaces", Name: "X"}, http.StatusUnprocessableEntity},
+		{"no ids", "", "POST", "/organizations/batch-get", BatchGet{}, http.StatusUnprocessableEntity},
+		{"too many ids", "", "POST", "/organizations/batch-get", BatchGet{IDs: tooMany}, http.StatusUnprocessableEntity},
+		{"batch get invalid JSON", "", "POST", "/organizations/batch-get", "[", http.StatusBadRequest},
+		{"unknown status filter", "", "GET", "/organizations?status=gone", nil, http.StatusBadRequest},
+		{"no username", "acme", "GET", "/users/check", nil, http.StatusUnprocessableEntity},
+		{"kindergarten invalid JSON", "acme", "POST", "/kindergartens", "{", http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.tenant != "" {
+				headers = []string{"X-Tenant-ID", tt.tenant}
+			}
+			rec := s.do(tt.method, tt.path, tt.body, headers...)
+			wantStatus(t, rec, tt.status)
+			if strings.TrimSpace(rec.Body.String()) == "" {
+				t.Error("no error message")
+			}
+		})
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	for _, tt := range []struct {
+		kind   error
+		status int
+	}{
+		{ErrNotFound, http.StatusNotFound},
+		{ErrConflict, http.StatusConflict},
+		{ErrBadRequest, http.StatusBadRequest},
+		{ErrUnprocessable, http.StatusUnprocessableEntity},
+		{ErrUnauthorized, http.StatusUnauthorized},
+		{ErrForbidden, http.StatusForbidden},
+		{ErrPreconditionFailed, http.StatusPreconditionFailed},
+		{ErrUnavailable, http.StatusServiceUnavailable},
+		{ErrTimeout, http.StatusGatewayTimeout},
+		{errors.New("boom"), http.StatusInternalServerError},
+	} {
+		cause := errors.New("cause")
+		err := fmt.Errorf("wrapped: %w", newError(tt.kind, "message", cause))
+		if got := errorStatus(err); got != tt.status {
+			t.Errorf("%v: status = %d, want %d", tt.kind, got, tt.status)
+		}
+		if !errors.Is(err, tt.kind) || !errors.Is(err, cause) {
+			t.Errorf("%v: errors.Is doesn't match both the kind and the cause", tt.kind)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		body string
+	}{
+		{newError(ErrNotFound, "organization not found", errors.New("record not found")), "organization not found"},
+		{fieldError("name", "is required", nil), "name is required"},
+		// Anything but an *Error may leak internals.
+		{errors.New("dial tcp 10.0.0.1:5432: connection refused"), "Internal Server Error"},
+	} {
+		rec := httptest.NewRecorder()
+		writeError(rec, tt.err)
+		if got := strings.TrimSpace(rec.Body.String()); got != tt.body {
+			t.Errorf("body = %q, want %q", got, tt.body)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	rec := s.do("POST", "/users", User{Username: "erin", Password: "secret", Role: "teacher"})
+	wantStatus(t, rec, http.StatusOK)
+	user := decode[User](t, rec)
+
+	for _, tt := range []struct {
+		path          string
+		before, after interface{}
+	}{
+		{"/organizations/acme", map[string]string{"name": "Acme"}, map[string]string{"name": "Acme Corp"}},
+		{fmt.Sprintf("/users/%d", user.ID), map[string]string{"role": "teacher"}, map[string]string{"role": "admin"}},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := s.do("GET", tt.path, nil)
+			wantStatus(t, rec, http.StatusOK)
+			tag := rec.Header().Get("ETag")
+			if tag == "" {
+				t.Fatal("no ETag")
+			}
+
+			rec = s.do("PUT", tt.path, tt.before, "If-Match", tag)
+			wantStatus(t, rec, http.StatusOK)
+			current := rec.Header().Get("ETag")
+			rec = s.do("PUT", tt.path, tt.after, "If-Match", current)
+			wantStatus(t, rec, http.StatusOK)
+
+			// The entity changed since current was read.
+			wantStatus(t, s.do("PUT", tt.path, tt.before, "If-Match", current), http.StatusPreconditionFailed)
+			wantStatus(t, s.do("PUT", tt.path, tt.before, "If-Match", `"other", `+rec.Header().Get("ETag")), http.StatusOK)
+			wantStatus(t, s.do("PUT", tt.path, tt.after, "If-Match", "*"), http.StatusOK)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OrganizationFilter narrows down organization listings.
+type OrganizationFilter struct {
+	// Status keeps the organizations in one of these statuses.
+	Status []OrganizationStatus
+	// Name keeps the organization with this name, compared as
+	// organizationNameKey compares them.
+	Name string
+	// Config maps key paths into Organization.Config, such as "driver" or
+	// "pool.max_open", to the value they must hold.
+	Config map[string]string
+}
+
+const configFilterPrefix = "config."
+
+var configKeyPath = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// parseOrganizationFilter reads the status=<status>[,<status>...],
+// name=<name> and config.<key path>=<value> query parameters.
+func parseOrganizationFilter(r *http.Request) (OrganizationFilter, error) {
+	var f OrganizationFilter
+	if v := r.URL.Query().Get("status"); v != "" {
+		for _, status := range strings.Split(v, ",") {
+			if _, ok := organizationTransitions[OrganizationStatus(status)]; !ok {
+				return f, newError(ErrBadRequest, "invalid status filter "+status, nil)
+			}
+			f.Status = append(f.Status, OrganizationStatus(status))
+		}
+	}
+	f.Name = r.URL.Query().Get("name")
+	for key, values := range r.URL.Query() {
+		path, ok := strings.CutPrefix(key, configFilterPrefix)
+		if !ok {
+			continue
+		}
+		if !configKeyPath.MatchString(path) {
+			return f, newError(ErrBadRequest, "invalid config filter "+key, nil)
+		}
+		if f.Config == nil {
+			f.Config = map[string]string{}
+		}
+		f.Config[path] = values[0]
+	}
+	return f, nil
+}
+
+// apply restricts a query on organizations to the filter. Config filters
+// use the JSON functions of the database, and fail on databases without
+// them.
+func (f OrganizationFilter) apply(db *gorm.DB) (*gorm.DB, error) {
+	if len(f.Status) > 0 {
+		db = db.Where("status IN ?", f.Status)
+	}
+	if f.Name != "" {
+		db = db.Where("name_key = ?", organizationNameKey(f.Name))
+	}
+	for path, value := range f.Config {
+		switch db.Dialector.Name() {
+		case "sqlite":
+			// Bare DSN configs are not JSON; json_extract would fail on them.
+			db = db.Where("CASE WHEN json_valid(config) THEN CAST(json_extract(config, ?) AS TEXT) END = ?", "$."+path, value)
+		case "postgres":
+			db = db.Where("config::jsonb #>> ? = ?", "{"+strings.ReplaceAll(path, ".", ",")+"}", value)
+		case "mysql":
+			db = db.Where("JSON_UNQUOTE(JSON_EXTRACT(config, ?)) = ?", "$."+path, value)
+		default:
+			return nil, newError(ErrBadRequest, "config filters are not supported by the database", nil)
+		}
+	}
+	return db, nil
+}
+
+// UserFilter narrows down user listings.
+type UserFilter struct {
+	// InactiveSince keeps the users who haven't logged in since then,
+	// including those who never did.
+	InactiveSince *time.Time
+}
+
+// parseUserFilter reads the inactive_since=<RFC 3339 time> query parameter.
+func parseUserFilter(r *http.Request) (UserFilter, error) {
+	var f UserFilter
+	if v := r.URL.Query().Get("inactive_since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, newError(ErrBadRequest, "invalid inactive_since", err)
+		}
+		f.InactiveSince = &t
+	}
+	return f, nil
+}
+
+// apply restricts a query on users to the filter.
+func (f UserFilter) apply(db *gorm.DB) *gorm.DB {
+	if f.InactiveSince != nil {
+		db = db.Where("last_login_at IS NULL OR last_login_at < ?", *f.InactiveSince)
+	}
+	return db
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestOrganizationConfigFilter(t *testing.T) {
+	s := newTestServer(t)
+	// Stored directly. Listing opens the tenant databases, so each has one.
+	for _, org := range []Organization{
+		{ID: "acme", Name: "Acme", Config: `{"dsn": "` + s.tenantDSN("acme") + `", "driver": "postgres", "pool": {"max_open": 10}}`},
+		{ID: "beta", Name: "Beta", Config: `{"dsn": "` + s.tenantDSN("beta") + `", "driver": "sqlite", "pool": {"max_open": 5}}`},
+		{ID: "gamma", Name: "Gamma", Config: `{"dsn": "` + s.tenantDSN("gamma") + `", "driver": "postgres"}`},
+		{ID: "delta", Name: "Delta", Config: s.tenantDSN("delta")},
+	} {
+		if err := centralDB.Create(&org).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"config.driver=postgres", []string{"acme", "gamma"}},
+		{"config.pool.max_open=10", []string{"acme"}},
+		{"config.driver=postgres&config.pool.max_open=5", nil},
+		{"config.driver=mysql", nil},
+	} {
+		rec := s.do("GET", "/organizations?"+tt.query, nil)
+		wantStatus(t, rec, http.StatusOK)
+		var got []string
+		for _, org := range decode[[]Organization](t, rec) {
+			got = append(got, org.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: organizations = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{"config.driver')--=x", "config.=x", "config.pool..max_open=1"} {
+		wantStatus(t, s.do("GET", "/organizations?"+query, nil), http.StatusBadRequest)
+	}
+}
+
+func TestCountOrganizations(t *testing.T) {
+	s := newTestServer(t)
+	for _, org := range []Organization{
+		{ID: "acme", Name: "Acme", Config: `{"driver": "postgres"}`, Status: StatusActive},
+		{ID: "beta", Name: "Beta", Config: `{"driver": "sqlite"}`, Status: StatusSuspended},
+		{ID: "gamma", Name: "Gamma", Config: `{"driver": "postgres"}`, Status: StatusSuspended},
+		{ID: "delta", Name: "Delta", Config: `{"driver": "postgres"}`, Status: StatusArchived},
+	} {
+		org.NameKey = organizationNameKey(org.Name)
+		if err := centralDB.Create(&org).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  int64
+	}{
+		{"", 4},
+		{"status=suspended", 2},
+		{"status=active,archived", 2},
+		{"name=%20ACME", 1},
+		{"config.driver=postgres&status=suspended", 1},
+		{"config.driver=mysql", 0},
+	} {
+		rec := s.do("GET", "/organizations/count?"+tt.query, nil)
+		wantStatus(t, rec, http.StatusOK)
+		if got := decode[map[string]int64](t, rec)["count"]; got != tt.want {
+			t.Errorf("%s: count = %d, want %d", tt.query, got, tt.want)
+		}
+		// The list takes the same filters.
+		if listed := len(decode[[]Organization](t, s.do("GET", "/organizations?"+tt.query, nil))); int64(listed) != tt.want {
+			t.Errorf("%s: listed %d organizations, want %d", tt.query, listed, tt.want)
+		}
+	}
+	wantStatus(t, s.do("GET", "/organizations/count?status=deleted", nil), http.StatusBadRequest)
+}
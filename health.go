@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// startupMigrationDone is set once the tenant migration run at startup by
+// Config.MigrateTenantsOnStartup has finished, or right away when there is
+// none.
+var startupMigrationDone atomic.Bool
+
+// readinessTimeout bounds the central database ping of /readyz.
+const readinessTimeout = 2 * time.Second
+
+// livez reports that the process is up. It checks nothing else, so that a
+// failing database doesn't get the process restarted.
+func livez(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// readyz reports whether the service can take traffic: the central
+// database answers and the startup tenant migration is done.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if !startupMigrationDone.Load() {
+		httpError(w, "migrating tenants", http.StatusServiceUnavailable)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if err := pingDB(ctx, centralDB); err != nil {
+		log.Printf("readiness: central database: %v", err)
+		httpError(w, "central database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
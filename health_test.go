@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLivenessAndReadiness(t *testing.T) {
+	s := newTestServer(t)
+	startupMigrationDone.Store(false)
+
+	wantStatus(t, s.do("GET", "/livez", nil), http.StatusOK)
+	wantStatus(t, s.do("GET", "/readyz", nil), http.StatusServiceUnavailable)
+
+	startupMigrationDone.Store(true)
+	wantStatus(t, s.do("GET", "/readyz", nil), http.StatusOK)
+
+	// Liveness doesn't depend on the database; readiness does.
+	closeDB(centralDB)
+	wantStatus(t, s.do("GET", "/livez", nil), http.StatusOK)
+	wantStatus(t, s.do("GET", "/readyz", nil), http.StatusServiceUnavailable)
+}
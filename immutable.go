@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// immutableFields lists, by model, the JSON names of the fields updates
+// can't change: keys, and fields only the service itself sets.
+var immutableFields = map[reflect.Type][]string{
+	reflect.TypeFor[Organization](): {"id"},
+	reflect.TypeFor[User]():         {"id", "last_login_at"},
+}
+
+// immutableSnapshot returns a copy of v for keepImmutable to compare with
+// once an update is decoded into v. Its immutable pointer fields point to
+// copies, which decoding into v, reusing the pointers, leaves alone.
+func immutableSnapshot[T any](v T) T {
+	sv := reflect.ValueOf(&v).Elem()
+	for _, name := range immutableFields[sv.Type()] {
+		f := sv.Field(jsonFieldIndex(sv.Type(), name))
+		if f.Kind() == reflect.Pointer && !f.IsNil() {
+			p := reflect.New(f.Type().Elem())
+			p.Elem().Set(f.Elem())
+			f.Set(p)
+		}
+	}
+	return v
+}
+
+// keepImmutable undoes the changes an update decoded into updated made to
+// the immutableFields of its model, restoring them from stored, an
+// immutableSnapshot taken before decoding. With
+// Config.RejectImmutableChanges it fails with ErrUnprocessable instead,
+// naming the first field changed. Fields sent with their stored value are
+// no change.
+func keepImmutable[T any](stored T, updated *T) error {
+	sv, uv := reflect.ValueOf(stored), reflect.ValueOf(updated).Elem()
+	for _, name := range immutableFields[sv.Type()] {
+		i := jsonFieldIndex(sv.Type(), name)
+		if immutableEqual(sv.Field(i), uv.Field(i)) {
+			continue
+		}
+		if cfg().RejectImmutableChanges {
+			return newError(ErrUnprocessable, fmt.Sprintf("%s can't be changed", name), nil)
+		}
+		uv.Field(i).Set(sv.Field(i))
+	}
+	return nil
+}
+
+// immutableEqual reports whether two values of an immutable field are the
+// same. Times are the same when they are the same instant, whatever their
+// location.
+func immutableEqual(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Pointer {
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		a, b = a.Elem(), b.Elem()
+	}
+	if t, ok := a.Interface().(time.Time); ok {
+		return t.Equal(b.Interface().(time.Time))
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// jsonFieldIndex returns the index of the field of struct type t encoded
+// as name. It panics for names t doesn't have, which immutableFields must
+// not list.
+func jsonFieldIndex(t reflect.Type, name string) int {
+	for i := 0; i < t.NumField(); i++ {
+		if tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); tag == name {
+			return i
+		}
+	}
+	panic(fmt.Sprintf("%s has no field %s", t, name))
+}
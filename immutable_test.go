@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImmutableFields(t *testing.T) {
+	s := newTestServer(t)
+	for _, username := range []string{"erin", "frank"} {
+		wantStatus(t, s.do("POST", "/users", User{Username: username, Password: "secret"}), http.StatusOK)
+	}
+	lastLogin := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := centralDB.Model(&User{ID: 1}).Update("last_login_at", lastLogin).Error; err != nil {
+		t.Fatal(err)
+	}
+	getUser := func(id string) User {
+		t.Helper()
+		rec := s.do("GET", "/users/"+id, nil)
+		wantStatus(t, rec, http.StatusOK)
+		return decode[User](t, rec)
+	}
+	update := map[string]interface{}{"id": 2, "username": "erin", "role": "admin", "last_login_at": "2000-01-01T00:00:00Z"}
+
+	// By default the changes to immutable fields are dropped.
+	wantStatus(t, s.do("PUT", "/users/1", update), http.StatusOK)
+	erin := getUser("1")
+	if erin.Role != "admin" || erin.LastLoginAt == nil || !erin.LastLoginAt.Equal(lastLogin) {
+		t.Errorf("erin = %+v, want the role changed and last login kept", erin)
+	}
+	if frank := getUser("2"); frank.Username != "frank" || frank.Role == "admin" {
+		t.Errorf("frank = %+v, overwritten by an update of erin", frank)
+	}
+
+	c := *cfg()
+	c.RejectImmutableChanges = true
+	setConfig(&c)
+	for field, value := range map[string]interface{}{"id": 2, "last_login_at": "2000-01-01T00:00:00Z"} {
+		rec := s.do("PUT", "/users/1", map[string]interface{}{"username": "erin", field: value})
+		wantStatus(t, rec, http.StatusUnprocessableEntity)
+		if !strings.HasPrefix(rec.Body.String(), field+" ") {
+			t.Errorf("error %q doesn't name %s", rec.Body.String(), field)
+		}
+	}
+	// Sending the stored values back is no change, whatever the time zone.
+	same := map[string]interface{}{"id": 1, "username": "erin", "role": "teacher", "last_login_at": lastLogin.In(time.FixedZone("", 5*60*60))}
+	wantStatus(t, s.do("PUT", "/users/1", same), http.StatusOK)
+
+	s.createTenant("acme")
+	org := decode[Organization](t, s.do("GET", "/organizations/acme", nil))
+	org.ID = "other"
+	wantStatus(t, s.do("PUT", "/organizations/acme", org), http.StatusUnprocessableEntity)
+	org.ID, org.Name = "acme", "Acme Corp"
+	wantStatus(t, s.do("PUT", "/organizations/acme", org), http.StatusOK)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+var snakeCase = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+// checkSnakeCase fails the test for every object key in v, at any depth,
+// that isn't snake_case.
+func checkSnakeCase(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if !snakeCase.MatchString(key) {
+				t.Errorf("%s: key %q isn't snake_case", path, key)
+			}
+			checkSnakeCase(t, path+"."+key, value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			checkSnakeCase(t, path+"[]", value)
+		}
+	}
+}
+
+func TestJSONFieldNames(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	if err := s.tenantDB("acme").Create(&Kindergarten{ID: "k1", Name: "K1"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens/k1/classrooms", Classroom{Name: "C1"}), http.StatusCreated)
+	wantStatus(t, s.tenant("acme", "PUT", "/settings/theme", `"dark"`), http.StatusCreated)
+	rec := s.do("POST", "/users", User{Username: "erin", Role: "teacher"})
+	wantStatus(t, rec, http.StatusOK)
+	// So that last_login_at isn't null.
+	if err := userService.RecordLogin(context.Background(), decode[User](t, rec).ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, rec := range map[string]*httptest.ResponseRecorder{
+		"/organizations":               s.do("GET", "/organizations", nil),
+		"/users":                       s.do("GET", "/users", nil),
+		"/kindergartens":               s.tenant("acme", "GET", "/kindergartens", nil),
+		"/kindergartens/k1/classrooms": s.tenant("acme", "GET", "/kindergartens/k1/classrooms", nil),
+		"/settings":                    s.tenant("acme", "GET", "/settings", nil),
+	} {
+		wantStatus(t, rec, http.StatusOK)
+		var v interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+			t.Fatal(err)
+		}
+		if list, _ := v.([]interface{}); len(list) == 0 {
+			t.Errorf("%s: nothing listed", path)
+		}
+		checkSnakeCase(t, path, v)
+	}
+}
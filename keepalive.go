@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// keepAliveIdleCheck is how often keepTenantDBsAlive looks at the config
+// again while keep-alive pings are turned off.
+const keepAliveIdleCheck = time.Minute
+
+// keepTenantDBsAlive pings the cached tenant databases every
+// Config.TenantKeepAliveInterval until ctx is done, see pingTenantDBs.
+func keepTenantDBsAlive(ctx context.Context) {
+	for {
+		interval := cfg().TenantKeepAliveInterval
+		if interval <= 0 {
+			if !sleep(ctx, keepAliveIdleCheck) {
+				return
+			}
+			continue
+		}
+		if !sleep(ctx, interval) {
+			return
+		}
+		pingTenantDBs(context.Background())
+	}
+}
+
+// pingTenantDBs pings every cached tenant database that isn't SQLite, so
+// that connections a server or firewall dropped while idle are noticed
+// before a request runs into them. Databases failing the ping are evicted
+// from the cache and closed once the requests using them are done; the
+// next request opens them again.
+func pingTenantDBs(ctx context.Context) {
+	tenantDBs.Lock()
+	dbs := make(map[string]*gorm.DB, len(tenantDBs.m))
+	for dsn, db := range tenantDBs.m {
+		// SQLite files don't go away while idle.
+		if db.Dialector.Name() != "sqlite" {
+			dbs[dsn] = db
+		}
+	}
+	tenantDBs.Unlock()
+
+	for dsn, db := range dbs {
+		if err := pingTenantDB(ctx, db); err != nil {
+			log.Printf("tenant database %s: keep-alive ping failed, evicting it: %s", redactDSN(dsn, dsn), redactDSN(err.Error(), dsn))
+			evictTenantDB(dsn, db)
+		}
+	}
+}
+
+// pingTenantDB pings db, giving up after Config.TenantOpenTimeout.
+func pingTenantDB(ctx context.Context, db *gorm.DB) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg().TenantOpenTimeout)
+	defer cancel()
+	return pingDB(ctx, db)
+}
+
+// tenantEvictGrace is how long an evicted tenant database stays open, so
+// that requests that got it from the cache before it was evicted can
+// finish with it.
+var tenantEvictGrace = time.Minute
+
+// evictTenantDB removes db from the cache, unless it was replaced in the
+// meantime, and closes it after tenantEvictGrace.
+func evictTenantDB(dsn string, db *gorm.DB) {
+	tenantDBs.Lock()
+	if tenantDBs.m[dsn] == db {
+		delete(tenantDBs.m, dsn)
+	}
+	tenantDBs.Unlock()
+	time.AfterFunc(tenantEvictGrace, func() { closeDB(db) })
+}
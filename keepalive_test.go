@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEvictTenantDB(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	grace := tenantEvictGrace
+	tenantEvictGrace = 100 * time.Millisecond
+	defer func() { tenantEvictGrace = grace }()
+
+	db := s.tenantDB("acme")
+	evictTenantDB(s.tenantDSN("acme"), db)
+	if cachedTenantDB(s.tenantDSN("acme")) != nil {
+		t.Error("evicted database still cached")
+	}
+	// A request that got it before the eviction can still use it.
+	var count int64
+	if err := db.Model(&Kindergarten{}).Count(&count).Error; err != nil {
+		t.Errorf("evicted database closed before the grace period: %v", err)
+	}
+
+	if reopened := s.tenantDB("acme"); reopened == db {
+		t.Error("the evicted database was reused")
+	}
+	time.Sleep(3 * tenantEvictGrace)
+	if err := pingDB(context.Background(), db); err == nil {
+		t.Error("evicted database still open after the grace period")
+	}
+}
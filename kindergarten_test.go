@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestCreateKindergarten(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+
+	rec := s.tenant("acme", "POST", "/kindergartens", Kindergarten{ID: "k1", Name: "Sunflower"})
+	wantStatus(t, rec, http.StatusCreated)
+	if got := decode[Kindergarten](t, rec); got.ID != "k1" || got.Name != "Sunflower" {
+		t.Errorf("created %+v", got)
+	}
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens", Kindergarten{ID: "k1", Name: "Daisy"}), http.StatusConflict)
+	if got := decode[Kindergarten](t, s.tenant("acme", "GET", "/kindergartens/k1", nil)); got.Name != "Sunflower" {
+		t.Errorf("name = %q after a duplicate create, want Sunflower", got.Name)
+	}
+
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ids := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		rec := s.tenant("acme", "POST", "/kindergartens", Kindergarten{Name: "Tulip"})
+		wantStatus(t, rec, http.StatusCreated)
+		id := decode[Kindergarten](t, rec).ID
+		if !uuid.MatchString(id) || ids[id] {
+			t.Errorf("generated ID %q, want a new UUID", id)
+		}
+		ids[id] = true
+	}
+}
+
+func TestKindergartensSince(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	for _, id := range []string{"k1", "k2", "k3"} {
+		wantStatus(t, s.tenant("acme", "POST", "/kindergartens", Kindergarten{ID: id, Name: id}), http.StatusCreated)
+	}
+	// The seed kindergartens 1 and 2 are new too.
+	old := time.Now().AddDate(0, 0, -2)
+	if err := s.tenantDB("acme").Model(&Kindergarten{}).Where("id IN ?", []string{"k1", "k2"}).UpdateColumn("updated_at", old).Error; err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, s.tenant("acme", "DELETE", "/kindergartens/k2", nil), http.StatusNoContent)
+
+	since := time.Now().AddDate(0, 0, -1).UTC().Format(time.RFC3339)
+	rec := s.tenant("acme", "GET", "/kindergartens?since="+since, nil)
+	wantStatus(t, rec, http.StatusOK)
+	var ids []string
+	for _, k := range decode[[]Kindergarten](t, rec) {
+		ids = append(ids, k.ID)
+		if deleted := k.DeletedAt.Valid; deleted != (k.ID == "k2") {
+			t.Errorf("%s: deleted_at = %v", k.ID, k.DeletedAt)
+		}
+	}
+	// k1 hasn't changed; k2 comes back as a tombstone.
+	if want := []string{"1", "2", "k2", "k3"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("kindergartens since %s = %v, want %v", since, ids, want)
+	}
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens?since=yesterday", nil), http.StatusBadRequest)
+}
+
+func TestSoftDeleteKindergarten(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens", Kindergarten{ID: "k1", Name: "Sunflower"}), http.StatusCreated)
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens/k1/classrooms", Classroom{Name: "Daisies"}), http.StatusCreated)
+	listed := func(path string) map[string]Kindergarten {
+		t.Helper()
+		rec := s.tenant("acme", "GET", path, nil)
+		wantStatus(t, rec, http.StatusOK)
+		byID := map[string]Kindergarten{}
+		for _, k := range decode[[]Kindergarten](t, rec) {
+			byID[k.ID] = k
+		}
+		return byID
+	}
+
+	wantStatus(t, s.tenant("acme", "DELETE", "/kindergartens/k1", nil), http.StatusNoContent)
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens/k1", nil), http.StatusNotFound)
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens/k1/classrooms", nil), http.StatusNotFound)
+	if _, ok := listed("/kindergartens")["k1"]; ok {
+		t.Error("deleted kindergarten listed")
+	}
+	if k, ok := listed("/kindergartens?include_deleted=true")["k1"]; !ok || !k.DeletedAt.Valid {
+		t.Errorf("?include_deleted lists %+v, want k1 with deleted_at", k)
+	}
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens/k1?include_deleted=true", nil), http.StatusOK)
+	var classrooms int64
+	s.tenantDB("acme").Model(&Classroom{}).Count(&classrooms)
+	if classrooms != 1 {
+		t.Errorf("%d classrooms left after a soft delete, want 1", classrooms)
+	}
+
+	rec := s.tenant("acme", "POST", "/kindergartens/k1/restore", nil)
+	wantStatus(t, rec, http.StatusOK)
+	if k := decode[Kindergarten](t, rec); k.DeletedAt.Valid {
+		t.Errorf("restored kindergarten has deleted_at %v", k.DeletedAt)
+	}
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens/k1", nil), http.StatusOK)
+	if list := decode[[]Classroom](t, s.tenant("acme", "GET", "/kindergartens/k1/classrooms", nil)); len(list) != 1 {
+		t.Errorf("classrooms after restore = %+v, want Daisies back", list)
+	}
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens/gone/restore", nil), http.StatusNotFound)
+}
+
+func TestKindergartenSeed(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	// Provisioning seeds them, not listing.
+	for _, id := range []string{"1", "2"} {
+		wantStatus(t, s.tenant("acme", "GET", "/kindergartens/"+id, nil), http.StatusOK)
+	}
+	if err := s.tenantDB("acme").Unscoped().Delete(&Kindergarten{}, "id IN ?", []string{"1", "2"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if list := decode[[]Kindergarten](t, s.tenant("acme", "GET", "/kindergartens", nil)); len(list) != 0 {
+		t.Errorf("kindergartens = %+v, want none", list)
+	}
+}
@@ -2,191 +2,484 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 type Organization struct {
-	ID     string `gorm:"primaryKey"`
-	Name   string
-	Config string `gorm:"type:json"`
-
-	Kindergartens []Kindergarten `gorm:"-:all"`
+	ID     string             `gorm:"primaryKey" json:"id"`
+	Name   string             `json:"name"`
+	Config string             `gorm:"type:json" json:"config"`
+	Status OrganizationStatus `gorm:"not null;default:active" json:"status"`
+	// NameKey makes names unique, see organizationNameKey.
+	NameKey *string `gorm:"uniqueIndex" json:"-"`
+
+	Kindergartens []Kindergarten `gorm:"-:all" json:"kindergartens"`
+	// KindergartensError says why Kindergartens couldn't be listed, when
+	// the tenant database failed.
+	KindergartensError string `gorm:"-:all" json:"kindergartens_error,omitempty"`
 	// Users []User `gorm:"many2many:organization_users;"`
 }
 
+// MarshalJSON redacts the webhook secret of the config, which would let
+// anyone reading the organization sign its webhooks.
+func (o Organization) MarshalJSON() ([]byte, error) {
+	type organization Organization
+	org := organization(o)
+	org.Config = redactTenantConfig(org.Config)
+	return json.Marshal(org)
+}
+
 type User struct {
-	ID       uint   `gorm:"primaryKey"`
-	Username string `gorm:"uniqueIndex"`
-	Password string
-	Role     string
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Username string `gorm:"uniqueIndex" json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+	// LastLoginAt is nil for users who never logged in.
+	LastLoginAt *time.Time `json:"last_login_at"`
 
 	// Organizations []*Organization `gorm:"many2many:organization_users;"`
 }
 
+// MarshalJSON leaves out the password, which requests set but responses
+// must never show, not even hashed.
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+	return json.Marshal(struct {
+		user
+		Password string `json:"password,omitempty"`
+	}{user: user(u)})
+}
+
 var centralDB *gorm.DB
 
 func initCentralDB() {
 	var err error
-	centralDB, err = gorm.Open(sqlite.Open("central.db"), &gorm.Config{})
+	centralDB, err = gorm.Open(openDialector(cfg().CentralDSN), &gorm.Config{TranslateError: true, Logger: queryLog, NowFunc: utcNow})
 	if err != nil {
 		log.Fatalf("failed to connect to central database: %v", err)
 	}
+	if err := registerCallbacks(centralDB, registerQueryTag("central")); err != nil {
+		log.Fatalf("failed to set up central database: %v", err)
+	}
 
-	centralDB.AutoMigrate(&Organization{})
-}
-
-func getTenantDB(dsn string) (*gorm.DB, error) {
-	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
-	if err != nil {
-		return nil, err
+	if err := centralDB.AutoMigrate(&Organization{}, &User{}, &OutboxEvent{}, &RateLimitBucket{}, &ProvisioningJob{}, &APIKey{}); err != nil {
+		log.Fatalf("failed to migrate central database: %v", err)
+	}
+	if err := backfillOrganizationNameKeys(centralDB); err != nil {
+		log.Fatalf("failed to migrate central database: %v", err)
 	}
-	db.AutoMigrate(&User{})
-	return db, nil
 }
 
 func TenantMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tenantID := r.Header.Get("X-Tenant-ID")
+		tenantID := strings.TrimSpace(r.Header.Get("X-Tenant-ID"))
+		// An API key picks the tenant itself; X-Tenant-ID may only
+		// repeat it.
+		key, err := authenticateAPIKey(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if key != nil {
+			if tenantID != "" && tenantID != key.OrganizationID {
+				writeError(w, newError(ErrForbidden, "API key belongs to another tenant", nil))
+				return
+			}
+			tenantID = key.OrganizationID
+		} else if cfg().RequireAPIKey {
+			writeError(w, newError(ErrUnauthorized, "API key is required", nil))
+			return
+		}
 		if tenantID == "" {
-			http.Error(w, "tenant ID is required", http.StatusBadRequest)
+			httpError(w, "tenant ID is required", http.StatusBadRequest)
+			return
+		}
+		// Not worth a query if it can't be an organization's.
+		if !tenantIDFormat.MatchString(tenantID) {
+			httpError(w, "tenant ID must be "+tenantIDFormatMessage, http.StatusBadRequest)
 			return
 		}
 
 		var organization Organization
-		if err := centralDB.Where("id = ?", tenantID).First(&organization).Error; err != nil {
-			http.Error(w, "invalid tenant ID", http.StatusBadRequest)
+		if err := centralDB.WithContext(r.Context()).Where("id = ?", tenantID).First(&organization).Error; err != nil {
+			httpError(w, "invalid tenant ID", http.StatusBadRequest)
 			return
 		}
 
-		db, err := getTenantDB(organization.Config)
+		switch organization.Status {
+		case StatusProvisioning:
+			writeError(w, newError(ErrUnavailable, "tenant is being provisioned", nil))
+			return
+		case StatusSuspended, StatusArchived:
+			writeError(w, newError(ErrForbidden, "tenant is "+string(organization.Status), nil))
+			return
+		}
+
+		tc, err := parseTenantConfig(organization.ID, organization.Config)
+		if errors.Is(err, errTenantNotConfigured) {
+			writeError(w, newError(ErrUnavailable, "tenant not configured", err))
+			return
+		}
+		if err != nil {
+			httpError(w, "invalid tenant config", http.StatusInternalServerError)
+			return
+		}
+		loc, err := tc.location()
 		if err != nil {
-			http.Error(w, "failed to connect to tenant database", http.StatusInternalServerError)
+			httpError(w, "invalid tenant config", http.StatusInternalServerError)
+			return
+		}
+		applyTenantCORS(w, r, tc)
+
+		db, err := getTenantDB(r.Context(), tc)
+		if err != nil {
+			writeError(w, tenantDBErr(err))
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), "tenantDB", db)
+		ctx = context.WithValue(ctx, tenantIDKey, organization.ID)
+		ctx = context.WithValue(ctx, tenantConfigKey, tc)
+		ctx = context.WithValue(ctx, tenantLocationKey, loc)
+		ctx = context.WithValue(ctx, tenantClientKey, newTenantClient(organization.ID))
+		if key != nil {
+			ctx = context.WithValue(ctx, apiKeyKey, key)
+		}
+		tenantRequests.Add(tenantMetricLabel(organization.ID), 1)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
 func main() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &logLevel})))
+	setConfig(loadConfig())
+	reloadConfigOnSIGHUP()
 	initCentralDB()
+	initTenantDBs()
+	organizationService = NewOrganizationService(centralDB)
+	userService = NewUserService(centralDB)
+
+	loops := startBackgroundLoops(dispatchOutbox, provisionTenants, keepTenantDBsAlive, purgeDeletedRecords)
+
+	// The server is up while tenants migrate, reporting not ready on
+	// /readyz until they are done.
+	if cfg().MigrateTenantsOnStartup {
+		go func() {
+			if _, err := migrateAllTenants(context.Background()); err != nil {
+				log.Fatalf("failed to migrate tenants: %v", err)
+			}
+			startupMigrationDone.Store(true)
+		}()
+	} else {
+		startupMigrationDone.Store(true)
+	}
+
+	log.Println("Starting server on :8080")
+
+	serve(&http.Server{Addr: ":8080", Handler: newRouter()}, loops)
+}
 
+// newRouter returns the handler serving every route.
+func newRouter() http.Handler {
 	r := chi.NewRouter()
+	r.Use(TrackInFlight)
+	r.Use(CountQueries)
+	r.Use(RealIP)
+	// Paths are canonical without a trailing slash; one is ignored.
+	r.Use(middleware.StripSlashes)
+	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
+	r.Use(CORS)
+	r.Use(APIVersion)
+
+	r.With(IPRateLimit).Get("/", root)
+	r.With(IPRateLimit).Get("/livez", livez)
+	r.With(IPRateLimit).Get("/readyz", readyz)
+	routes(r)
+	r.Route("/v2", func(r chi.Router) {
+		r.Use(forceAPIVersion(apiV2))
+		routes(r)
+	})
+	return r
+}
 
+func routes(r chi.Router) {
 	// Organization CRUD
 	r.Route("/organizations", func(r chi.Router) {
+		r.Use(IPRateLimit)
 		r.Post("/", createOrganization)
 		r.Get("/", listOrganizations)
+		r.Get("/count", countOrganizations)
+		r.Post("/batch-get", batchGetOrganizations)
 		r.Get("/{id}", getOrganization)
+		r.Get("/{id}/provisioning", getProvisioningStatus)
 		r.Put("/{id}", updateOrganization)
 		r.Delete("/{id}", deleteOrganization)
 	})
 
 	// User CRUD
 	r.Route("/users", func(r chi.Router) {
-		r.Post("/", createUser)
-		r.Get("/", listUsers)
-		r.Get("/{id}", getUser)
-		r.Put("/{id}", updateUser)
-		r.Delete("/{id}", deleteUser)
+		r.Group(func(r chi.Router) {
+			r.Use(IPRateLimit)
+			r.Post("/", createUser)
+			r.Post("/batch", importUsers)
+			r.Post("/login", loginUser)
+			r.Get("/", listUsers)
+			r.Get("/{id}", getUser)
+			r.Put("/{id}", updateUser)
+			r.Delete("/{id}", deleteUser)
+		})
+		r.With(TenantMiddleware, RequireScope("users:read"), TenantRateLimit, LimitTenantConcurrency).Get("/check", checkUsername)
+		r.With(TenantMiddleware, RequireScope("users:read"), TenantRateLimit, LimitTenantConcurrency).Get("/stats", getUserStats)
 	})
 
 	r.Route("/kindergartens", func(r chi.Router) {
-		r.Use(TenantMiddleware)
+		r.Use(TenantMiddleware, RequireResourceScope("kindergartens"), TenantRateLimit, CacheTenantReads, LimitTenantConcurrency)
 		r.Get("/", listKindergartens)
+		r.Post("/", createKindergarten)
+		r.Get("/{id}", getKindergarten)
+		r.Delete("/{id}", deleteKindergarten)
+		r.Post("/{id}/restore", restoreKindergarten)
+		r.Route("/{id}/classrooms", func(r chi.Router) {
+			r.Get("/", listClassrooms)
+			r.Post("/", createClassroom)
+			r.Get("/{classroomID}", getClassroom)
+			r.Put("/{classroomID}", updateClassroom)
+			r.Delete("/{classroomID}", deleteClassroom)
+		})
 	})
 
-	log.Println("Starting server on :8080")
-
-	err := http.ListenAndServe(":8080", r)
+	r.Route("/settings", func(r chi.Router) {
+		r.Use(TenantMiddleware, RequireResourceScope("settings"), TenantRateLimit, CacheTenantReads, LimitTenantConcurrency)
+		r.Get("/", listSettings)
+		r.Get("/{key}", getSetting)
+		r.Put("/{key}", putSetting)
+		r.Delete("/{key}", deleteSetting)
+	})
 
-	if err != nil {
-		panic(fmt.Sprintf("cannot start server: %s", err))
-	}
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(IPRateLimit, AdminMiddleware)
+		r.Get("/stats", getStats)
+		r.Get("/health", getHealth)
+		r.Handle("/metrics", expvar.Handler())
+		r.Get("/users/password-hashes", getPasswordHashReport)
+		r.Post("/tenants/test-connection", testConnection)
+		r.Post("/tenants/{id}/clone", cloneTenant)
+		r.Post("/tenants/{id}/migrate", migrateTenant)
+		r.Get("/migrations/status", getMigrationStatus)
+		r.Get("/tenants/{id}/config", getTenantConfig)
+		r.Get("/tenants/{id}/usage", getTenantUsage)
+		r.Get("/tenants/{id}/api-keys", listAPIKeys)
+		r.Post("/tenants/{id}/api-keys", mintAPIKey)
+		r.Delete("/tenants/{id}/api-keys/{keyID}", revokeAPIKey)
+		r.Post("/organizations/{id}/rename", renameOrganization)
+	})
 }
 
+var (
+	organizationService *OrganizationService
+	userService         *UserService
+)
+
 func createOrganization(w http.ResponseWriter, r *http.Request) {
 	var org Organization
-	if err := json.NewDecoder(r.Body).Decode(&org); err != nil {
-		http.Error(w, "invalid input", http.StatusBadRequest)
+	if err := decodeJSON(r, &org); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := org.validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	if cfg().AsyncProvisioning {
+		org, err := organizationService.CreateAsync(r.Context(), org)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/organizations/"+org.ID+"/provisioning")
+		w.Header().Set("ETag", etag(org))
+		writeJSON(w, r, http.StatusAccepted, org)
 		return
 	}
-	if err := centralDB.Create(&org).Error; err != nil {
-		http.Error(w, "could not create organization", http.StatusInternalServerError)
+	org, err := organizationService.Create(r.Context(), org)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(org)
+	w.Header().Set("ETag", etag(org))
+	// Stored, but waiting for a DSN before it can be provisioned.
+	if org.Status == StatusProvisioning {
+		w.Header().Set("Location", "/organizations/"+org.ID+"/provisioning")
+		writeJSON(w, r, http.StatusAccepted, org)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, org)
 }
 
 func listOrganizations(w http.ResponseWriter, r *http.Request) {
-	var organizations []Organization
-	if err := centralDB.Find(&organizations).Error; err != nil {
-		http.Error(w, "could not list organizations", http.StatusInternalServerError)
+	page, err := parsePage(r, "id", "name")
+	if err != nil {
+		writeError(w, newError(ErrBadRequest, err.Error(), nil))
 		return
 	}
-
-	for i, org := range organizations {
-		tenantDB, err := getTenantDB(org.Config)
-		if err != nil {
-			http.Error(w, "failed to connect to tenant database", http.StatusInternalServerError)
+	filter, err := parseOrganizationFilter(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if wantsTotal(r) {
+		if page.Total, err = organizationService.Count(r.Context(), filter); err != nil {
+			writeError(w, err)
 			return
 		}
+	}
 
-		var kindergartens []Kindergarten
-		if err := tenantDB.Find(&kindergartens).Error; err != nil {
-			http.Error(w, "could not list kindergartens", http.StatusInternalServerError)
-			return
-		}
+	organizations, err := organizationService.List(r.Context(), page, filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	err = page.checkTruncated(len(organizations), func() (int64, error) {
+		return organizationService.Count(r.Context(), filter)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeList(w, r, organizations, page)
+}
 
-		org.Kindergartens = kindergartens
-		organizations[i] = org
+// OrganizationCount is the number of organizations matching a filter.
+type OrganizationCount struct {
+	Count int64 `json:"count"`
+}
 
+// countOrganizations counts the organizations matching the filters
+// listOrganizations takes, without loading them.
+func countOrganizations(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseOrganizationFilter(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	n, err := organizationService.Count(r.Context(), filter)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
-	json.NewEncoder(w).Encode(organizations)
+	writeJSON(w, r, http.StatusOK, OrganizationCount{Count: n})
+}
+
+type BatchGet struct {
+	IDs []string `json:"ids"`
+}
+
+// BatchGetResult is the outcome for one of the requested IDs.
+type BatchGetResult struct {
+	ID           string        `json:"id"`
+	Found        bool          `json:"found"`
+	Organization *Organization `json:"organization,omitempty"`
+}
+
+// batchGetOrganizations fetches up to Config.BatchGetMaxIDs organizations
+// at once, answering for each ID in the order they were requested.
+func batchGetOrganizations(w http.ResponseWriter, r *http.Request) {
+	var req BatchGet
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, newError(ErrUnprocessable, "ids is required", nil))
+		return
+	}
+	if max := cfg().BatchGetMaxIDs; len(req.IDs) > max {
+		writeError(w, newError(ErrUnprocessable, fmt.Sprintf("at most %d ids may be requested at once", max), nil))
+		return
+	}
+
+	organizations, err := organizationService.GetMany(r.Context(), req.IDs)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	results := make([]BatchGetResult, len(req.IDs))
+	for i, id := range req.IDs {
+		results[i] = BatchGetResult{ID: id}
+		if org, ok := organizations[id]; ok {
+			results[i].Found = true
+			results[i].Organization = &org
+		}
+	}
+	writeJSON(w, r, http.StatusOK, results)
 }
 
 func getOrganization(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	var organization Organization
-	if err := centralDB.First(&organization, "id = ?", id).Error; err != nil {
-		http.Error(w, "organization not found", http.StatusNotFound)
+	organization, err := organizationService.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(organization)
+	w.Header().Set("ETag", etag(organization))
+	writeJSON(w, r, http.StatusOK, organization)
 }
 
 func updateOrganization(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	var organization Organization
-	if err := centralDB.First(&organization, "id = ?", id).Error; err != nil {
-		http.Error(w, "organization not found", http.StatusNotFound)
+	organization, err := organizationService.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !ifMatch(r, etag(organization)) {
+		writeError(w, newError(ErrPreconditionFailed, "organization has been modified", nil))
+		return
+	}
+	stored := immutableSnapshot(organization)
+	if err := decodeJSON(r, &organization); err != nil {
+		writeError(w, err)
+		return
+	}
+	organization.Config = keepWebhookSecret(stored.Config, organization.Config)
+	if err := keepImmutable(stored, &organization); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := organization.validate(); err != nil {
+		writeError(w, err)
 		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&organization); err != nil {
-		http.Error(w, "invalid input", http.StatusBadRequest)
+	if err := checkStatusTransition(stored.Status, organization.Status); err != nil {
+		writeError(w, err)
 		return
 	}
-	if err := centralDB.Save(&organization).Error; err != nil {
-		http.Error(w, "could not update organization", http.StatusInternalServerError)
+	organization, err = organizationService.Update(r.Context(), organization)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(organization)
+	w.Header().Set("ETag", etag(organization))
+	writeJSON(w, r, http.StatusOK, organization)
 }
 
 func deleteOrganization(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	if err := centralDB.Delete(&Organization{}, "id = ?", id).Error; err != nil {
-		http.Error(w, "could not delete organization", http.StatusInternalServerError)
+	if err := organizationService.Delete(r.Context(), chi.URLParam(r, "id")); err != nil {
+		writeError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -194,80 +487,355 @@ func deleteOrganization(w http.ResponseWriter, r *http.Request) {
 
 func createUser(w http.ResponseWriter, r *http.Request) {
 	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "invalid input", http.StatusBadRequest)
+	if err := decodeJSON(r, &user); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := user.validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	user, err := userService.Create(r.Context(), user)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(user))
+	writeJSON(w, r, http.StatusOK, user)
+}
+
+// loginUser checks a username and password, records the login and answers
+// the user.
+func loginUser(w http.ResponseWriter, r *http.Request) {
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &credentials); err != nil {
+		writeError(w, err)
 		return
 	}
-	if err := centralDB.Create(&user).Error; err != nil {
-		http.Error(w, "could not create user", http.StatusInternalServerError)
+	if credentials.Username == "" || credentials.Password == "" {
+		writeError(w, newError(ErrUnprocessable, "username and password are required", nil))
 		return
 	}
-	json.NewEncoder(w).Encode(user)
+	user, err := userService.Authenticate(r.Context(), credentials.Username, credentials.Password)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, user)
 }
 
 func listUsers(w http.ResponseWriter, r *http.Request) {
-	var users []User
-	if err := centralDB.Find(&users).Error; err != nil {
-		http.Error(w, "could not list users", http.StatusInternalServerError)
+	page, err := parsePage(r, "id", "username", "role", "last_login_at")
+	if err != nil {
+		writeError(w, newError(ErrBadRequest, err.Error(), nil))
 		return
 	}
-	json.NewEncoder(w).Encode(users)
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if wantsTotal(r) {
+		if page.Total, err = userService.Count(r.Context(), filter); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	users, err := userService.List(r.Context(), page, filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	err = page.checkTruncated(len(users), func() (int64, error) {
+		return userService.Count(r.Context(), filter)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeList(w, r, users, page)
 }
 
 func getUser(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	var user User
-	if err := centralDB.First(&user, "id = ?", id).Error; err != nil {
-		http.Error(w, "user not found", http.StatusNotFound)
+	user, err := userService.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(user)
+	w.Header().Set("ETag", etag(user))
+	writeJSON(w, r, http.StatusOK, user)
 }
 
 func updateUser(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	var user User
-	if err := centralDB.First(&user, "id = ?", id).Error; err != nil {
-		http.Error(w, "user not found", http.StatusNotFound)
+	user, err := userService.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "invalid input", http.StatusBadRequest)
+	if !ifMatch(r, etag(user)) {
+		writeError(w, newError(ErrPreconditionFailed, "user has been modified", nil))
 		return
 	}
-	if err := centralDB.Save(&user).Error; err != nil {
-		http.Error(w, "could not update user", http.StatusInternalServerError)
+	stored := immutableSnapshot(user)
+	if err := decodeJSON(r, &user); err != nil {
+		writeError(w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(user)
+	if err := keepImmutable(stored, &user); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := user.validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	user, err = userService.Update(r.Context(), user)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(user))
+	writeJSON(w, r, http.StatusOK, user)
 }
 
 func deleteUser(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	if err := centralDB.Delete(&User{}, "id = ?", id).Error; err != nil {
-		http.Error(w, "could not delete user", http.StatusInternalServerError)
+	if err := userService.Delete(r.Context(), chi.URLParam(r, "id")); err != nil {
+		writeError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// checkUsername reports whether a username is still free, see
+// UserService.UsernameTaken.
+func checkUsername(w http.ResponseWriter, r *http.Request) {
+	username := normalizeUsername(r.URL.Query().Get("username"))
+	if username == "" {
+		writeError(w, newError(ErrUnprocessable, "username is required", nil))
+		return
+	}
+
+	taken, err := userService.UsernameTaken(r.Context(), username)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]bool{"available": !taken})
+}
+
+type RoleCount struct {
+	Role  string `json:"role"`
+	Users int64  `json:"users"`
+}
+
+type UserStats struct {
+	Roles []RoleCount `json:"roles"`
+	Total int64       `json:"total"`
+}
+
+// getUserStats counts the users by role, see UserService.CountByRole.
+func getUserStats(w http.ResponseWriter, r *http.Request) {
+	roles, err := userService.CountByRole(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	stats := UserStats{Roles: roles}
+	for _, rc := range stats.Roles {
+		stats.Total += rc.Users
+	}
+	writeJSON(w, r, http.StatusOK, stats)
+}
+
 type Kindergarten struct {
-	ID   string `gorm:"primaryKey"`
-	Name string
+	ID        string    `gorm:"primaryKey" json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `gorm:"index" json:"updated_at"`
+	// DeletedAt is set on kindergartens deleted softly, which queries leave
+	// out unless they are Unscoped.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+
+	// Classrooms are deleted by the database along with their
+	// kindergarten, when it is deleted permanently.
+	Classrooms []Classroom `gorm:"constraint:OnDelete:CASCADE" json:"classrooms,omitempty"`
+}
+
+// includeDeleted reports whether a request asks for softly deleted rows
+// too, with ?include_deleted=true.
+func includeDeleted(r *http.Request) bool {
+	include, _ := strconv.ParseBool(r.URL.Query().Get("include_deleted"))
+	return include
 }
 
 func listKindergartens(w http.ResponseWriter, r *http.Request) {
 
-	tenantDB := r.Context().Value("tenantDB").(*gorm.DB)
-	tenantDB.AutoMigrate(&Kindergarten{})
+	tenantDB := r.Context().Value("tenantDB").(*gorm.DB).WithContext(r.Context())
+
+	page, err := parsePage(r, "id", "name")
+	if err == nil {
+		err = parseCursor(r, &page)
+	}
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if includeDeleted(r) {
+		tenantDB = tenantDB.Unscoped().Session(&gorm.Session{})
+	}
+	// ?since returns the kindergartens changed since then, for clients
+	// syncing incrementally, including those deleted since then, with
+	// their deleted_at set, so that clients delete them too.
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpError(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+		tenantDB = tenantDB.Unscoped().Where("updated_at >= ? OR deleted_at >= ?", since, since).Session(&gorm.Session{})
+	}
+	if wantsTotal(r) {
+		if err := tenantDB.Model(&Kindergarten{}).Count(&page.Total).Error; err != nil {
+			httpError(w, "could not list kindergartens", http.StatusInternalServerError)
+			return
+		}
+	}
 
-	tenantDB.Create(&Kindergarten{ID: "1", Name: "Kindergarten 1"})
-	tenantDB.Create(&Kindergarten{ID: "2", Name: "Kindergarten 2"})
+	if wantsStream(r) {
+		streamList(w, r, tenantDB, page, func(k Kindergarten) string { return k.ID })
+		return
+	}
 
 	var kindergartens []Kindergarten
-	if err := tenantDB.Find(&kindergartens).Error; err != nil {
-		http.Error(w, "could not list kindergartens", http.StatusInternalServerError)
+	if err := page.paginate(tenantDB).Find(&kindergartens).Error; err != nil {
+		httpError(w, "could not list kindergartens", http.StatusInternalServerError)
+		return
+	}
+	err = page.checkTruncated(len(kindergartens), func() (int64, error) {
+		var n int64
+		return n, tenantDB.Model(&Kindergarten{}).Count(&n).Error
+	})
+	if err != nil {
+		httpError(w, "could not list kindergartens", http.StatusInternalServerError)
+		return
+	}
+	if n := len(kindergartens); n > 0 {
+		page.setNextCursor(n, kindergartens[n-1].ID)
+	}
+	writeList(w, r, kindergartens, page)
+}
+
+func getKindergarten(w http.ResponseWriter, r *http.Request) {
+	tenantDB := r.Context().Value("tenantDB").(*gorm.DB).WithContext(r.Context())
+	if includeDeleted(r) {
+		tenantDB = tenantDB.Unscoped().Session(&gorm.Session{})
+	}
+
+	var kindergarten Kindergarten
+	if err := firstOr404(tenantDB, &kindergarten, chi.URLParam(r, "id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(kindergarten))
+	writeJSON(w, r, http.StatusOK, kindergarten)
+}
+
+// createKindergarten adds a kindergarten to the tenant's database, with a
+// random UUID as its ID unless the client picks one.
+func createKindergarten(w http.ResponseWriter, r *http.Request) {
+	tenantDB := r.Context().Value("tenantDB").(*gorm.DB).WithContext(r.Context())
+
+	var kindergarten Kindergarten
+	if err := decodeJSON(r, &kindergarten); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := kindergarten.validate(); err != nil {
+		writeError(w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(kindergartens)
+	if kindergarten.ID == "" {
+		kindergarten.ID = newUUID()
+	}
+	// Classrooms are created under /kindergartens/{id}/classrooms.
+	kindergarten.Classrooms = nil
+
+	err := tenantDB.Create(&kindergarten).Error
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		writeError(w, newError(ErrConflict, "kindergarten "+kindergarten.ID+" already exists", err))
+		return
+	case err != nil:
+		writeError(w, newError(nil, "could not create kindergarten", err))
+		return
+	}
+	if err := firstOr404(tenantDB, &kindergarten, kindergarten.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(kindergarten))
+	writeJSON(w, r, http.StatusCreated, kindergarten)
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// deleteKindergarten deletes a kindergarten softly, leaving a tombstone
+// that ?since reports and restoreKindergarten can bring back. Its
+// classrooms stay, out of reach while it is deleted. ?permanent=true
+// deletes it for good instead, deleted softly or not; the database deletes
+// its classrooms with it.
+func deleteKindergarten(w http.ResponseWriter, r *http.Request) {
+	tenantDB := r.Context().Value("tenantDB").(*gorm.DB).WithContext(r.Context())
+	permanent, _ := strconv.ParseBool(r.URL.Query().Get("permanent"))
+	if permanent {
+		tenantDB = tenantDB.Unscoped().Session(&gorm.Session{})
+	}
+
+	var kindergarten Kindergarten
+	if err := firstOr404(tenantDB, &kindergarten, chi.URLParam(r, "id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := tenantDB.Delete(&kindergarten).Error; err != nil {
+		writeError(w, newError(nil, "could not delete kindergarten", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreKindergarten undoes the soft deletion of a kindergarten, along
+// with its classrooms. Restoring one that isn't deleted changes nothing.
+func restoreKindergarten(w http.ResponseWriter, r *http.Request) {
+	tenantDB := r.Context().Value("tenantDB").(*gorm.DB).WithContext(r.Context()).Unscoped().Session(&gorm.Session{})
+
+	var kindergarten Kindergarten
+	if err := firstOr404(tenantDB, &kindergarten, chi.URLParam(r, "id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	if kindergarten.DeletedAt.Valid {
+		// Updating bumps updated_at, so ?since reports the kindergarten
+		// again.
+		if err := tenantDB.Model(&kindergarten).Update("deleted_at", nil).Error; err != nil {
+			writeError(w, newError(nil, "could not restore kindergarten", err))
+			return
+		}
+		if err := firstOr404(tenantDB, &kindergarten, kindergarten.ID); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	w.Header().Set("ETag", etag(kindergarten))
+	writeJSON(w, r, http.StatusOK, kindergarten)
 }
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// testAdminToken is the admin token of the servers newTestServer starts.
+const testAdminToken = "test-admin-token"
+
+func TestMain(m *testing.M) {
+	// The request and query logs would drown the test output.
+	log.SetOutput(io.Discard)
+	middleware.DefaultLogger = middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: log.Default(), NoColor: true})
+	os.Exit(m.Run())
+}
+
+// testServer runs the service in process, against SQLite databases in a
+// temporary directory. The background workers, such as the outbox
+// dispatcher, aren't started; tests run what they need of them by hand.
+type testServer struct {
+	t       *testing.T
+	dir     string
+	handler http.Handler
+}
+
+// newTestServer sets the service up with the default configuration, which
+// configure may change, and tears it down when the test ends. The global
+// state it touches makes tests using it unfit for t.Parallel.
+func newTestServer(t *testing.T, configure ...func(*Config)) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("CONFIG_FILE", "")
+	c := loadConfig()
+	c.CentralDSN = filepath.Join(dir, "central.db")
+	c.AdminToken = testAdminToken
+	c.PasswordHashCost = bcrypt.MinCost
+	c.StatsCacheTTL = 0
+	c.TenantKeepAliveInterval = 0
+	c.PurgeInterval = 0
+	for _, f := range configure {
+		f(c)
+	}
+	setConfig(c)
+	initCentralDB()
+	initTenantDBs()
+	organizationService = NewOrganizationService(centralDB)
+	userService = NewUserService(centralDB)
+	startupMigrationDone.Store(true)
+
+	t.Cleanup(func() {
+		closeTenantDBs()
+		resetStatsCache()
+		usageCache.Lock()
+		clear(usageCache.m)
+		usageCache.Unlock()
+		tenantOpenSlots = nil
+		tenantBreakers.Lock()
+		clear(tenantBreakers.m)
+		tenantBreakers.Unlock()
+		tenantCache = newMemoryCache()
+		tenantRateLimiter = newRateLimiter()
+		ipRateLimiter = newRateLimiter()
+		closeDB(centralDB)
+		resetTenantOrigins()
+	})
+	return &testServer{t: t, dir: dir, handler: newRouter()}
+}
+
+// do serves a request and returns its response. Bodies other than strings
+// are sent encoded as JSON. headers are name and value pairs.
+func (s *testServer) do(method, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			s.t.Fatalf("encoding request body: %v", err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// admin serves a request to the admin API.
+func (s *testServer) admin(method, path string, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.do(method, path, body, "Authorization", "Bearer "+testAdminToken)
+}
+
+// tenant serves a request to the routes of a tenant.
+func (s *testServer) tenant(id, method, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.do(method, path, body, append([]string{"X-Tenant-ID", id}, headers...)...)
+}
+
+// tenantConfig returns the config of a tenant with its own SQLite database
+// in the server's directory.
+func (s *testServer) tenantConfig(id string) string {
+	return s.tenantDSN(id)
+}
+
+func (s *testServer) tenantDSN(id string) string {
+	return filepath.Join(s.dir, id+".db")
+}
+
+// createTenant creates an organization with a provisioned database of its
+// own and returns it.
+func (s *testServer) createTenant(id string) Organization {
+	s.t.Helper()
+	rec := s.do("POST", "/organizations", Organization{ID: id, Name: "Organization " + id, Config: s.tenantConfig(id)})
+	wantStatus(s.t, rec, http.StatusOK)
+	org := decode[Organization](s.t, rec)
+	if org.Status != StatusActive {
+		s.t.Fatalf("organization %s is %s, not active", id, org.Status)
+	}
+	return org
+}
+
+// tenantDB returns the database of a tenant created by createTenant.
+func (s *testServer) tenantDB(id string) *gorm.DB {
+	s.t.Helper()
+	db, err := getTenantDB(context.Background(), TenantConfig{DSN: s.tenantDSN(id)})
+	if err != nil {
+		s.t.Fatalf("opening the database of tenant %s: %v", id, err)
+	}
+	return db
+}
+
+// wantStatus fails the test unless the response has the given status.
+func wantStatus(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, status, strings.TrimSpace(rec.Body.String()))
+	}
+}
+
+// decode decodes the JSON body of a response.
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+func TestCheckUsername(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	wantStatus(t, s.do("POST", "/users", User{Username: "Erin", Password: "secret"}), http.StatusOK)
+	// Stored before usernames were lowercased.
+	if err := centralDB.Create(&User{Username: "Legacy"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		username  string
+		available bool
+	}{
+		{"erin", false},
+		{"ERIN", false},
+		{"legacy", false},
+		{"frank", true},
+	} {
+		rec := s.tenant("acme", "GET", "/users/check?username="+tt.username, nil)
+		wantStatus(t, rec, http.StatusOK)
+		if got := decode[map[string]bool](t, rec)["available"]; got != tt.available {
+			t.Errorf("%s: available = %v, want %v", tt.username, got, tt.available)
+		}
+	}
+}
+
+func TestGetUserStats(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	for i, role := range []string{"teacher", "admin", "teacher", "parent", "teacher"} {
+		user := User{Username: fmt.Sprintf("user%d", i), Password: "secret", Role: role}
+		wantStatus(t, s.do("POST", "/users", user), http.StatusOK)
+	}
+
+	rec := s.tenant("acme", "GET", "/users/stats", nil)
+	wantStatus(t, rec, http.StatusOK)
+	want := UserStats{
+		Roles: []RoleCount{{"admin", 1}, {"parent", 1}, {"teacher", 3}},
+		Total: 5,
+	}
+	if got := decode[UserStats](t, rec); !reflect.DeepEqual(got, want) {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"expvar"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// Per-tenant counters, served by GET /admin/metrics. Tenants listed in
+// Config.MetricsTenants are counted under their own ID and all others
+// together under otherTenants, which keeps the number of labels bounded.
+var (
+	tenantRequests = expvar.NewMap("tenant_requests")
+	tenantDBOps    = expvar.NewMap("tenant_db_ops")
+)
+
+const otherTenants = "other"
+
+func tenantMetricLabel(id string) string {
+	if slices.Contains(cfg().MetricsTenants, id) {
+		return id
+	}
+	return otherTenants
+}
+
+// registerTenantMetrics makes a tenant database count the statements run
+// for a tenant's requests in tenantDBOps. Statements run outside of a
+// request, such as migrations, aren't counted.
+func registerTenantMetrics(db *gorm.DB) error {
+	return afterEachStatement(db, "metrics", func(tx *gorm.DB) {
+		if id := tenantID(tx.Statement.Context); id != "" {
+			tenantDBOps.Add(tenantMetricLabel(id), 1)
+		}
+	})
+}
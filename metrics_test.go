@@ -0,0 +1,46 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"testing"
+)
+
+func TestTenantMetrics(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.MetricsTenants = []string{"acme"} })
+	for _, id := range []string{"acme", "beta", "gamma"} {
+		s.createTenant(id)
+	}
+	// The counters are process-wide, so only their changes are checked.
+	value := func(m *expvar.Map, label string) int64 {
+		if v, ok := m.Get(label).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	requests := map[string]int64{"acme": value(tenantRequests, "acme"), otherTenants: value(tenantRequests, otherTenants)}
+	ops := map[string]int64{"acme": value(tenantDBOps, "acme"), otherTenants: value(tenantDBOps, otherTenants)}
+
+	for _, id := range []string{"acme", "beta", "gamma"} {
+		wantStatus(t, s.tenant(id, "GET", "/kindergartens", nil), http.StatusOK)
+	}
+
+	for label, want := range map[string]int64{"acme": 1, otherTenants: 2} {
+		if got := value(tenantRequests, label) - requests[label]; got != want {
+			t.Errorf("%s requests = %d, want %d", label, got, want)
+		}
+		if value(tenantDBOps, label) == ops[label] {
+			t.Errorf("no %s database statements counted", label)
+		}
+	}
+	if tenantRequests.Get("beta") != nil {
+		t.Error("beta counted under its own label")
+	}
+
+	rec := s.admin("GET", "/admin/metrics", nil)
+	wantStatus(t, rec, http.StatusOK)
+	metrics := decode[map[string]any](t, rec)
+	if _, ok := metrics["tenant_requests"]; !ok {
+		t.Errorf("metrics %s have no tenant_requests", rec.Body.String())
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MigrationStatusReport is the schema state of every tenant database.
+type MigrationStatusReport struct {
+	LatestVersion string                  `json:"latest_version"`
+	Tenants       []TenantMigrationStatus `json:"tenants"`
+	GeneratedAt   time.Time               `json:"generated_at"`
+}
+
+// migrationStates are the values of ?state on /admin/migrations/status.
+var migrationStates = []string{"current", "behind", "failed"}
+
+// migrationState classifies a tenant's status for filtering: "failed"
+// when it has an error, "behind" when it misses migrations, and "current"
+// otherwise.
+func (s TenantMigrationStatus) migrationState() string {
+	switch {
+	case s.Error != "":
+		return "failed"
+	case s.Behind:
+		return "behind"
+	default:
+		return "current"
+	}
+}
+
+// getMigrationStatus reports the schema version of every tenant database,
+// without migrating any. ?state=behind,failed keeps the tenants in one of
+// the given states. Error is the failure of the last migration this process
+// ran on the tenant, or the one reading its version.
+func getMigrationStatus(w http.ResponseWriter, r *http.Request) {
+	var states []string
+	if v := r.URL.Query().Get("state"); v != "" {
+		for _, state := range strings.Split(v, ",") {
+			if !slices.Contains(migrationStates, state) {
+				writeError(w, newError(ErrBadRequest, "state must be current, behind or failed", nil))
+				return
+			}
+			states = append(states, state)
+		}
+	}
+
+	var organizations []Organization
+	if err := centralDB.WithContext(r.Context()).Find(&organizations).Error; err != nil {
+		writeError(w, newError(nil, "could not list organizations", err))
+		return
+	}
+
+	statuses := make([]TenantMigrationStatus, len(organizations))
+	sem := make(chan struct{}, max(cfg().StatsConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, org := range organizations {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			statuses[i] = tenantMigrationStatus(r.Context(), org)
+		}()
+	}
+	wg.Wait()
+
+	report := MigrationStatusReport{
+		LatestVersion: latestTenantVersion(),
+		Tenants:       []TenantMigrationStatus{},
+		GeneratedAt:   time.Now().UTC(),
+	}
+	for _, s := range statuses {
+		if len(states) == 0 || slices.Contains(states, s.migrationState()) {
+			report.Tenants = append(report.Tenants, s)
+		}
+	}
+	writeJSON(w, r, http.StatusOK, report)
+}
+
+// tenantMigrationStatus reads the schema version of an organization's
+// tenant database. Databases that aren't cached are opened for the check
+// only, and not migrated.
+func tenantMigrationStatus(ctx context.Context, org Organization) TenantMigrationStatus {
+	status := newTenantMigrationStatus(org)
+
+	tc, err := parseTenantConfig(org.ID, org.Config)
+	if err != nil {
+		status.Error = "invalid tenant config"
+		return status
+	}
+	db := cachedTenantDB(tc.DSN)
+	if db == nil {
+		if err := checkTenantDBExists(tc.DSN); err != nil {
+			status.Error = redactDSN(err.Error(), tc.DSN)
+			return status
+		}
+		if db, err = openTenantDB(ctx, tc.DSN); err != nil {
+			status.Error = redactDSN(err.Error(), tc.DSN)
+			return status
+		}
+		defer closeDB(db)
+	}
+
+	if status.Version, err = tenantSchemaVersion(db.WithContext(ctx)); err != nil {
+		status.Error = redactDSN(err.Error(), tc.DSN)
+		return status
+	}
+	status.Behind = status.Version != latestTenantVersion()
+	if e, ok := migrationErrors.Load(tc.DSN); ok {
+		status.Error = redactDSN(e.(string), tc.DSN)
+	}
+	return status
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SchemaMigration records a migration applied to a tenant database.
+type SchemaMigration struct {
+	ID        string `gorm:"primaryKey"`
+	AppliedAt time.Time
+}
+
+type migration struct {
+	ID      string
+	Migrate func(tx *gorm.DB) error
+}
+
+// tenantSchemaMigrations are applied to tenant databases in order. Only
+// ever append to it: applied migrations are never run again. Migrations
+// work on the frozen models below, never on the current ones, so that a
+// database migrated from scratch goes through the same schemas as one
+// migrated along the way.
+var tenantSchemaMigrations = []migration{
+	{ID: "0001_initial", Migrate: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&userV1{}, &kindergartenV1{})
+	}},
+	{ID: "0002_users_last_login_at", Migrate: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&userV2{})
+	}},
+	{ID: "0003_kindergarten_timestamps", Migrate: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&kindergartenV3{})
+	}},
+	{ID: "0004_classrooms", Migrate: func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&classroomV4{}); err != nil {
+			return err
+		}
+		return tx.Migrator().CreateConstraint(&kindergartenV4{}, "Classrooms")
+	}},
+	{ID: "0005_settings", Migrate: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&settingV5{})
+	}},
+	{ID: "0006_kindergarten_deleted_at", Migrate: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&kindergartenV6{})
+	}},
+}
+
+// The models as tenantSchemaMigrations left them, named after the first
+// migration to use them. Never change them; add a migration and a model
+// instead.
+
+type userV1 struct {
+	ID       uint   `gorm:"primaryKey"`
+	Username string `gorm:"uniqueIndex"`
+	Password string
+	Role     string
+}
+
+func (userV1) TableName() string { return "users" }
+
+type kindergartenV1 struct {
+	ID   string `gorm:"primaryKey"`
+	Name string
+}
+
+func (kindergartenV1) TableName() string { return "kindergartens" }
+
+type userV2 struct {
+	ID          uint   `gorm:"primaryKey"`
+	Username    string `gorm:"uniqueIndex"`
+	Password    string
+	Role        string
+	LastLoginAt *time.Time
+}
+
+func (userV2) TableName() string { return "users" }
+
+type kindergartenV3 struct {
+	ID        string `gorm:"primaryKey"`
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time `gorm:"index"`
+}
+
+func (kindergartenV3) TableName() string { return "kindergartens" }
+
+type classroomV4 struct {
+	ID             uint   `gorm:"primaryKey"`
+	KindergartenID string `gorm:"not null;index"`
+	Name           string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (classroomV4) TableName() string { return "classrooms" }
+
+type kindergartenV4 struct {
+	ID         string `gorm:"primaryKey"`
+	Name       string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time     `gorm:"index"`
+	Classrooms []classroomV4 `gorm:"foreignKey:KindergartenID;constraint:OnDelete:CASCADE"`
+}
+
+func (kindergartenV4) TableName() string { return "kindergartens" }
+
+type settingV5 struct {
+	Key       string          `gorm:"column:id;primaryKey"`
+	Value     json.RawMessage `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+func (settingV5) TableName() string { return "settings" }
+
+type kindergartenV6 struct {
+	ID         string `gorm:"primaryKey"`
+	Name       string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time      `gorm:"index"`
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
+	Classrooms []classroomV4  `gorm:"foreignKey:KindergartenID;constraint:OnDelete:CASCADE"`
+}
+
+func (kindergartenV6) TableName() string { return "kindergartens" }
+
+func latestTenantVersion() string {
+	return tenantSchemaMigrations[len(tenantSchemaMigrations)-1].ID
+}
+
+// tenantMigrationHooks are extra migrations for the tenants listing the
+// hook's name in TenantConfig.MigrationHooks, such as seed data or tables
+// only some tenants need. They run after tenantSchemaMigrations and are
+// recorded as "<hook>/<migration ID>". As with tenantSchemaMigrations,
+// only ever append to a hook.
+var tenantMigrationHooks = map[string][]migration{}
+
+// tenantMigrationsWithHooks lists the standard migrations followed by those
+// of the given hooks.
+func tenantMigrationsWithHooks(hooks []string) ([]migration, error) {
+	migrations := slices.Clone(tenantSchemaMigrations)
+	for _, name := range hooks {
+		hook, ok := tenantMigrationHooks[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown migration hook %q", name)
+		}
+		for _, m := range hook {
+			migrations = append(migrations, migration{ID: name + "/" + m.ID, Migrate: m.Migrate})
+		}
+	}
+	return migrations, nil
+}
+
+// runTenantMigrations applies the pending migrations, including those of
+// the given hooks, each in its own transaction together with its
+// schema_migrations record, and returns the resulting schema version.
+func runTenantMigrations(db *gorm.DB, hooks []string) (string, error) {
+	migrations, err := tenantMigrationsWithHooks(hooks)
+	if err != nil {
+		return "", err
+	}
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return "", err
+	}
+	var applied []string
+	if err := db.Model(&SchemaMigration{}).Pluck("id", &applied).Error; err != nil {
+		return "", err
+	}
+
+	for _, m := range migrations {
+		if slices.Contains(applied, m.ID) {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Migrate(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{ID: m.ID, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return latestTenantVersion(), nil
+}
+
+// tenantSchemaVersion returns the last migration applied to a tenant
+// database, or "" when none was.
+func tenantSchemaVersion(db *gorm.DB) (string, error) {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return "", nil
+	}
+	var applied []string
+	if err := db.Model(&SchemaMigration{}).Pluck("id", &applied).Error; err != nil {
+		return "", err
+	}
+	for i := len(tenantSchemaMigrations) - 1; i >= 0; i-- {
+		if slices.Contains(applied, tenantSchemaMigrations[i].ID) {
+			return tenantSchemaMigrations[i].ID, nil
+		}
+	}
+	return "", nil
+}
+
+// errTenantSchemaNewer is returned for tenant databases carrying migrations
+// this version of the service doesn't know, applied by a newer one during a
+// rolling deploy.
+var errTenantSchemaNewer = errors.New("tenant database schema is newer than the service")
+
+// unknownTenantMigrations returns the migrations applied to a tenant
+// database that are neither in tenantSchemaMigrations nor in a hook of
+// tenantMigrationHooks.
+func unknownTenantMigrations(db *gorm.DB) ([]string, error) {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return nil, nil
+	}
+	var applied []string
+	if err := db.Model(&SchemaMigration{}).Order("id").Pluck("id", &applied).Error; err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, m := range tenantSchemaMigrations {
+		known[m.ID] = true
+	}
+	for name, hook := range tenantMigrationHooks {
+		for _, m := range hook {
+			known[name+"/"+m.ID] = true
+		}
+	}
+	var unknown []string
+	for _, id := range applied {
+		if !known[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	return unknown, nil
+}
+
+// checkTenantSchemaNewer fails with errTenantSchemaNewer when the tenant
+// database has unknownTenantMigrations, rather than letting queries run
+// against a schema they weren't written for. With
+// Config.AllowNewerTenantSchema it only logs them.
+func checkTenantSchemaNewer(dsn string, db *gorm.DB) error {
+	unknown, err := unknownTenantMigrations(db)
+	if err != nil || len(unknown) == 0 {
+		return err
+	}
+	if cfg().AllowNewerTenantSchema {
+		log.Printf("tenant database %s: schema has migrations this version doesn't know: %s", redactDSN(dsn, dsn), strings.Join(unknown, ", "))
+		return nil
+	}
+	return fmt.Errorf("%w: unknown migrations %s", errTenantSchemaNewer, strings.Join(unknown, ", "))
+}
+
+type TenantMigrationStatus struct {
+	OrganizationID string `json:"organization_id"`
+	Version        string `json:"version"`
+	LatestVersion  string `json:"latest_version"`
+	// Behind is set for tenants missing some of tenantSchemaMigrations.
+	Behind bool   `json:"behind"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newTenantMigrationStatus(org Organization) TenantMigrationStatus {
+	return TenantMigrationStatus{OrganizationID: org.ID, LatestVersion: latestTenantVersion(), Behind: true}
+}
+
+// migrationErrors holds, by DSN, the error of the last migration this
+// process ran on a tenant database, for as long as it keeps failing.
+var migrationErrors sync.Map
+
+func recordMigrationResult(dsn string, err error) {
+	if err != nil {
+		migrationErrors.Store(dsn, err.Error())
+	} else {
+		migrationErrors.Delete(dsn)
+	}
+}
+
+// migrateAllTenants migrates the database of every organization and
+// reports the schema version each ends up on.
+func migrateAllTenants(ctx context.Context) ([]TenantMigrationStatus, error) {
+	var organizations []Organization
+	if err := centralDB.WithContext(ctx).Find(&organizations).Error; err != nil {
+		return nil, err
+	}
+
+	statuses := make([]TenantMigrationStatus, 0, len(organizations))
+	for _, org := range organizations {
+		status := migrateOrganization(ctx, org)
+		if status.Error != "" {
+			log.Printf("tenant %s: migration failed at version %q: %s", org.ID, status.Version, status.Error)
+		} else {
+			log.Printf("tenant %s: at version %s", org.ID, status.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func migrateOrganization(ctx context.Context, org Organization) TenantMigrationStatus {
+	status := newTenantMigrationStatus(org)
+
+	tc, err := parseTenantConfig(org.ID, org.Config)
+	if err != nil {
+		status.Error = "invalid tenant config"
+		return status
+	}
+	if err := checkTenantDBExists(tc.DSN); err != nil {
+		status.Error = redactDSN(err.Error(), tc.DSN)
+		return status
+	}
+	db, err := openTenantDB(ctx, tc.DSN)
+	if err != nil {
+		status.Error = redactDSN(err.Error(), tc.DSN)
+		return status
+	}
+	defer closeDB(db)
+
+	migrateErr := migrateTenantOnce(tc, db)
+	if status.Version, err = tenantSchemaVersion(db); err != nil {
+		status.Error = redactDSN(err.Error(), tc.DSN)
+		return status
+	}
+	status.Behind = status.Version != latestTenantVersion()
+	if migrateErr != nil {
+		status.Error = redactDSN(migrateErr.Error(), tc.DSN)
+	}
+	return status
+}
+
+// forceMigrateOrganization migrates an organization's tenant database even
+// if this process already did, so that migrations whose schema_migrations
+// record was deleted run again. It fails with ErrConflict, without
+// touching the database, when checkTenantSchemaNewer refuses it: this
+// version's models would take a newer schema back.
+func forceMigrateOrganization(ctx context.Context, org Organization) (TenantMigrationStatus, error) {
+	status := newTenantMigrationStatus(org)
+
+	tc, err := parseTenantConfig(org.ID, org.Config)
+	if err != nil {
+		status.Error = "invalid tenant config"
+		return status, nil
+	}
+	if err := checkTenantDBExists(tc.DSN); err != nil {
+		status.Error = redactDSN(err.Error(), tc.DSN)
+		return status, nil
+	}
+	db, err := openTenantDB(ctx, tc.DSN)
+	if err != nil {
+		status.Error = redactDSN(err.Error(), tc.DSN)
+		return status, nil
+	}
+	defer closeDB(db)
+	if err := checkTenantSchemaNewer(tc.DSN, db); err != nil {
+		if errors.Is(err, errTenantSchemaNewer) {
+			return status, newError(ErrConflict, "tenant database schema is newer than the service", err)
+		}
+		status.Error = redactDSN(err.Error(), tc.DSN)
+		return status, nil
+	}
+
+	migrateErr := applyTenantMigrations(tc, db, true)
+	if status.Version, err = tenantSchemaVersion(db); err != nil {
+		status.Error = redactDSN(err.Error(), tc.DSN)
+		return status, nil
+	}
+	status.Behind = status.Version != latestTenantVersion()
+	if migrateErr != nil {
+		status.Error = redactDSN(migrateErr.Error(), tc.DSN)
+	}
+	return status, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// organizationNameKey is the form organization names are compared in for
+// uniqueness: with runs of whitespace collapsed and, with
+// Config.CaseInsensitiveOrgNames, in lower case. It is stored in
+// Organization.NameKey, whose unique index enforces it.
+func organizationNameKey(name string) *string {
+	key := strings.Join(strings.Fields(name), " ")
+	if cfg().CaseInsensitiveOrgNames {
+		key = strings.ToLower(key)
+	}
+	return &key
+}
+
+// backfillOrganizationNameKeys sets the NameKey of organizations saved
+// before it existed. Organizations whose name is already taken keep none,
+// and are logged so that one of them can be renamed.
+func backfillOrganizationNameKeys(db *gorm.DB) error {
+	var organizations []Organization
+	if err := db.Where("name_key IS NULL").Find(&organizations).Error; err != nil {
+		return err
+	}
+	for _, org := range organizations {
+		err := db.Model(&org).Update("name_key", organizationNameKey(org.Name)).Error
+		switch {
+		case errors.Is(err, gorm.ErrDuplicatedKey):
+			log.Printf("organization %s: name %q is also used by another organization, rename one of them", org.ID, org.Name)
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOrganizationNamesUnique(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	beta := s.createTenant("beta")
+
+	rec := s.do("POST", "/organizations", Organization{ID: "other", Name: "  organization   ACME ", Config: s.tenantConfig("other")})
+	wantStatus(t, rec, http.StatusConflict)
+	if !strings.Contains(rec.Body.String(), "already taken") {
+		t.Errorf("error = %q, want the name reported as taken", rec.Body.String())
+	}
+	rec = s.do("POST", "/organizations", Organization{ID: "acme", Name: "Another", Config: s.tenantConfig("acme")})
+	wantStatus(t, rec, http.StatusConflict)
+	if got := strings.TrimSpace(rec.Body.String()); got != "organization already exists" {
+		t.Errorf("error = %q, want a taken ID reported", got)
+	}
+
+	beta.Name = "ORGANIZATION ACME"
+	wantStatus(t, s.do("PUT", "/organizations/beta", beta), http.StatusConflict)
+	// Keeping its own name in another case is fine.
+	beta.Name = "organization beta"
+	wantStatus(t, s.do("PUT", "/organizations/beta", beta), http.StatusOK)
+
+	c := *cfg()
+	c.CaseInsensitiveOrgNames = false
+	setConfig(&c)
+	wantStatus(t, s.do("POST", "/organizations", Organization{ID: "other", Name: "Organization ACME", Config: s.tenantConfig("other")}), http.StatusOK)
+}
+
+func TestBackfillOrganizationNameKeys(t *testing.T) {
+	newTestServer(t)
+	// Saved before names had keys.
+	for _, org := range []Organization{
+		{ID: "a", Name: "Acme"},
+		{ID: "b", Name: "ACME"},
+		{ID: "c", Name: "Beta"},
+	} {
+		if err := centralDB.Create(&org).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := backfillOrganizationNameKeys(centralDB); err != nil {
+		t.Fatal(err)
+	}
+	var organizations []Organization
+	if err := centralDB.Order("id").Find(&organizations).Error; err != nil {
+		t.Fatal(err)
+	}
+	var keyed []string
+	for _, org := range organizations {
+		if org.NameKey != nil {
+			keyed = append(keyed, *org.NameKey)
+		}
+	}
+	// One of the duplicates keeps no key.
+	if strings.Join(keyed, ",") != "acme,beta" {
+		t.Errorf("name keys = %q, want acme and beta", keyed)
+	}
+}
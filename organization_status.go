@@ -0,0 +1,37 @@
+package main
+
+import "slices"
+
+// OrganizationStatus is the lifecycle state of an organization.
+type OrganizationStatus string
+
+const (
+	// StatusProvisioning organizations wait for their tenant database.
+	StatusProvisioning OrganizationStatus = "provisioning"
+	StatusActive       OrganizationStatus = "active"
+	// StatusSuspended organizations keep their data but are refused
+	// service.
+	StatusSuspended OrganizationStatus = "suspended"
+	// StatusArchived is final.
+	StatusArchived OrganizationStatus = "archived"
+)
+
+// organizationTransitions lists the statuses each status may move to.
+var organizationTransitions = map[OrganizationStatus][]OrganizationStatus{
+	StatusProvisioning: {StatusActive, StatusArchived},
+	StatusActive:       {StatusSuspended, StatusArchived},
+	StatusSuspended:    {StatusActive, StatusArchived},
+	StatusArchived:     {},
+}
+
+// checkStatusTransition validates moving an organization from one status
+// to another. Staying in the same status is always allowed.
+func checkStatusTransition(from, to OrganizationStatus) error {
+	if _, ok := organizationTransitions[to]; !ok {
+		return newError(ErrUnprocessable, "invalid status "+string(to), nil)
+	}
+	if from != to && !slices.Contains(organizationTransitions[from], to) {
+		return newError(ErrUnprocessable, "organization can't move from "+string(from)+" to "+string(to), nil)
+	}
+	return nil
+}
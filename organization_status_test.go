@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOrganizationStatusTransitions(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	setStatus := func(status OrganizationStatus, want int) {
+		t.Helper()
+		org := decode[Organization](t, s.do("GET", "/organizations/acme", nil))
+		org.Status = status
+		wantStatus(t, s.do("PUT", "/organizations/acme", org), want)
+	}
+	tenantStatus := func(want int, message string) {
+		t.Helper()
+		rec := s.tenant("acme", "GET", "/kindergartens", nil)
+		wantStatus(t, rec, want)
+		if got := strings.TrimSpace(rec.Body.String()); message != "" && got != message {
+			t.Errorf("error = %q, want %q", got, message)
+		}
+	}
+
+	setStatus(StatusSuspended, http.StatusOK)
+	tenantStatus(http.StatusForbidden, "tenant is suspended")
+	setStatus(StatusProvisioning, http.StatusUnprocessableEntity)
+	setStatus("deleted", http.StatusUnprocessableEntity)
+	setStatus(StatusActive, http.StatusOK)
+	tenantStatus(http.StatusOK, "")
+	setStatus(StatusActive, http.StatusOK)
+
+	setStatus(StatusArchived, http.StatusOK)
+	tenantStatus(http.StatusForbidden, "tenant is archived")
+	for _, status := range []OrganizationStatus{StatusActive, StatusSuspended, StatusProvisioning} {
+		setStatus(status, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestCheckStatusTransition(t *testing.T) {
+	all := []OrganizationStatus{StatusProvisioning, StatusActive, StatusSuspended, StatusArchived}
+	allowed := map[[2]OrganizationStatus]bool{
+		{StatusProvisioning, StatusActive}:   true,
+		{StatusProvisioning, StatusArchived}: true,
+		{StatusActive, StatusSuspended}:      true,
+		{StatusActive, StatusArchived}:       true,
+		{StatusSuspended, StatusActive}:      true,
+		{StatusSuspended, StatusArchived}:    true,
+	}
+	for _, from := range all {
+		for _, to := range all {
+			want := from == to || allowed[[2]OrganizationStatus{from, to}]
+			if got := checkStatusTransition(from, to) == nil; got != want {
+				t.Errorf("%s to %s allowed = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
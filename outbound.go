@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const tenantClientKey contextKey = "tenantClient"
+
+// outboundTimeout bounds the calls made through tenantClient.
+const outboundTimeout = 10 * time.Second
+
+// tenantTransport adds the tenant's ID, and the ID of the request that led
+// to the call, to outbound requests, so the services called can tell on
+// whose behalf they are.
+type tenantTransport struct {
+	tenant string
+	base   http.RoundTripper
+}
+
+func (t tenantTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not change the request it was given.
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Tenant-ID", t.tenant)
+	if id := middleware.GetReqID(req.Context()); id != "" {
+		req.Header.Set(middleware.RequestIDHeader, id)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newTenantClient returns an HTTP client for calls made on behalf of the
+// given tenant. Its requests carry the request ID of their context.
+func newTenantClient(tenant string) *http.Client {
+	return &http.Client{
+		Transport: tenantTransport{tenant: tenant, base: http.DefaultTransport},
+		Timeout:   outboundTimeout,
+	}
+}
+
+// tenantClient returns the HTTP client TenantMiddleware set up for the
+// tenant it resolved. Handlers calling other services use it, with
+// requests bound to the incoming request's context, so that the tenant
+// and request IDs go along. Outside of tenant routes it is a plain client.
+func tenantClient(ctx context.Context) *http.Client {
+	if c, ok := ctx.Value(tenantClientKey).(*http.Client); ok {
+		return c
+	}
+	return &http.Client{Timeout: outboundTimeout}
+}
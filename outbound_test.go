@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestTenantClientAddsTenantAndRequestIDs(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+
+	received := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	handler := middleware.RequestID(TenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		resp, err := tenantClient(r.Context()).Do(req)
+		if err != nil {
+			t.Errorf("outbound request: %v", err)
+			return
+		}
+		resp.Body.Close()
+	})))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set(middleware.RequestIDHeader, "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	h := <-received
+	if got := h.Get("X-Tenant-ID"); got != "acme" {
+		t.Errorf("X-Tenant-ID = %q, want acme", got)
+	}
+	if got := h.Get(middleware.RequestIDHeader); got != "req-1" {
+		t.Errorf("%s = %q, want req-1", middleware.RequestIDHeader, got)
+	}
+}
+
+func TestTenantClientOutsideTenantRoutes(t *testing.T) {
+	received := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	resp, err := tenantClient(context.Background()).Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := (<-received).Get("X-Tenant-ID"); got != "" {
+		t.Errorf("X-Tenant-ID = %q, want none", got)
+	}
+}
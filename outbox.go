@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxEvent is a webhook event waiting in the central database to be
+// delivered. Events are written in the transaction of the change they
+// describe, so a committed change always has its event, even if the
+// process dies before delivering it. Delivery is at least once; receivers
+// deduplicate on the event ID.
+type OutboxEvent struct {
+	ID             uint `gorm:"primaryKey"`
+	EventID        string
+	OrganizationID string `gorm:"index"`
+	Type           string
+	Body           []byte
+	Attempts       int
+	LastError      string
+	CreatedAt      time.Time
+	// NextAttemptAt is when a failed delivery is due to be retried, nil
+	// until a delivery fails.
+	NextAttemptAt *time.Time `gorm:"index"`
+	// SentAt is nil until the event is delivered.
+	SentAt *time.Time `gorm:"index"`
+}
+
+const (
+	// outboxMaxAttempts is the number of deliveries of an event after
+	// which it is left in the outbox with its LastError for operators to
+	// look at.
+	outboxMaxAttempts = 8
+	outboxBatchSize   = 100
+	outboxInterval    = 5 * time.Second
+	// outboxRetryDelay is the delay before retrying an event that failed
+	// once. It doubles with every further failure.
+	outboxRetryDelay = 5 * time.Second
+)
+
+// outboxWake nudges the dispatcher after an event was committed, so it
+// doesn't wait for its next round.
+var outboxWake = make(chan struct{}, 1)
+
+// enqueueOrganizationEvent adds an event about org to the outbox within tx,
+// if the organization has a webhook. The organization's config is left out
+// of the event since it holds the webhook secret.
+func enqueueOrganizationEvent(tx *gorm.DB, eventType string, org Organization) error {
+	tc, err := parseTenantConfig(org.ID, org.Config)
+	if err != nil || tc.WebhookURL == "" {
+		return nil
+	}
+	org.Config = ""
+	event := WebhookEvent{
+		ID:        newEventID(),
+		Type:      eventType,
+		TenantID:  org.ID,
+		CreatedAt: time.Now().UTC(),
+		Data:      org,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&OutboxEvent{EventID: event.ID, OrganizationID: org.ID, Type: eventType, Body: body}).Error
+}
+
+// wakeOutbox tells the dispatcher that events are waiting.
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchOutbox delivers outbox events until ctx is done, finishing the
+// round under way. Events left over from a previous run are picked up on
+// the first round.
+func dispatchOutbox(ctx context.Context) {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+	for {
+		if err := dispatchOutboxBatch(); err != nil {
+			log.Printf("outbox: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-outboxWake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dispatchOutboxBatch delivers the events that are due. Tenants are
+// delivered to concurrently, so that a slow or failing webhook only holds
+// up its own tenant's events. Each tenant's are delivered in order, and
+// once one fails the rest wait for the next round, so that receivers see
+// them in order.
+func dispatchOutboxBatch() error {
+	var events []OutboxEvent
+	err := centralDB.Where("sent_at IS NULL AND attempts < ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", outboxMaxAttempts, time.Now()).
+		Order("id").Limit(outboxBatchSize).Find(&events).Error
+	if err != nil {
+		return err
+	}
+	byTenant := map[string][]OutboxEvent{}
+	for _, event := range events {
+		byTenant[event.OrganizationID] = append(byTenant[event.OrganizationID], event)
+	}
+
+	var wg sync.WaitGroup
+	for _, events := range byTenant {
+		wg.Add(1)
+		go func(events []OutboxEvent) {
+			defer wg.Done()
+			for _, event := range events {
+				if !deliverOutboxEvent(event) {
+					return
+				}
+			}
+		}(events)
+	}
+	wg.Wait()
+	return nil
+}
+
+// deliverOutboxEvent makes one delivery of the event, and records its
+// outcome. A failed delivery is scheduled to be retried after a delay
+// rather than retried on the spot, which would hold up the dispatcher. It
+// reports whether the event is done with.
+func deliverOutboxEvent(event OutboxEvent) bool {
+	now := time.Now()
+	err := deliverOutboxEventWebhook(event)
+	if errors.Is(err, errNoWebhook) {
+		log.Printf("tenant %s: dropping %s event %s: %v", event.OrganizationID, event.Type, event.EventID, err)
+		err = nil
+	}
+
+	updates := map[string]interface{}{"attempts": event.Attempts + 1}
+	if err != nil {
+		log.Printf("tenant %s: could not deliver %s event %s: %v", event.OrganizationID, event.Type, event.EventID, err)
+		updates["last_error"] = err.Error()
+		updates["next_attempt_at"] = now.Add(outboxRetryDelay << event.Attempts)
+	} else {
+		updates["sent_at"] = now
+	}
+	if err := centralDB.Model(&event).Updates(updates).Error; err != nil {
+		log.Printf("outbox: could not update event %s: %v", event.EventID, err)
+	}
+	return err == nil
+}
+
+var errNoWebhook = errors.New("organization no longer has a webhook")
+
+// deliverOutboxEventWebhook sends the event to the organization's current
+// webhook.
+func deliverOutboxEventWebhook(event OutboxEvent) error {
+	var org Organization
+	err := centralDB.First(&org, "id = ?", event.OrganizationID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errNoWebhook
+	}
+	if err != nil {
+		return err
+	}
+	tc, err := parseTenantConfig(org.ID, org.Config)
+	if err != nil || tc.WebhookURL == "" {
+		return errNoWebhook
+	}
+	return deliverWebhook(tc, event.Type, event.Body)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func outboxEvent(t *testing.T, tenant string) OutboxEvent {
+	t.Helper()
+	var event OutboxEvent
+	if err := centralDB.Where("organization_id = ?", tenant).Order("id").First(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestOutboxRetriesLater(t *testing.T) {
+	s := newTestServer(t, allowPrivateWebhooks)
+	var calls atomic.Int32
+	rcv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer rcv.Close()
+	org := map[string]string{"id": "acme", "name": "Acme", "config": webhookConfig(s, "acme", rcv.URL, "s3cret")}
+	wantStatus(t, s.do("POST", "/organizations", org), http.StatusOK)
+
+	// The failed delivery is recorded, not retried on the spot.
+	start := time.Now()
+	if err := dispatchOutboxBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("dispatching took %s", elapsed)
+	}
+	event := outboxEvent(t, "acme")
+	if event.Attempts != 1 || event.SentAt != nil || event.LastError == "" {
+		t.Errorf("event has %d attempts, sent at %v, last error %q; want a failed attempt", event.Attempts, event.SentAt, event.LastError)
+	}
+	if event.NextAttemptAt == nil || !event.NextAttemptAt.After(time.Now()) {
+		t.Fatalf("next attempt at %v, want later", event.NextAttemptAt)
+	}
+
+	// Not before it is due.
+	if err := dispatchOutboxBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("%d deliveries, want 1", got)
+	}
+
+	if err := centralDB.Model(&event).Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatchOutboxBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if event := outboxEvent(t, "acme"); event.Attempts != 2 || event.SentAt == nil {
+		t.Errorf("event has %d attempts, sent at %v; want sent on the second", event.Attempts, event.SentAt)
+	}
+}
+
+func TestOutboxDeliversTenantsConcurrently(t *testing.T) {
+	s := newTestServer(t, allowPrivateWebhooks)
+	betaReceived := make(chan struct{})
+	var waited atomic.Bool
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-betaReceived:
+		case <-time.After(2 * time.Second):
+			waited.Store(true)
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(betaReceived)
+	}))
+	defer fast.Close()
+
+	for id, url := range map[string]string{"acme": slow.URL, "beta": fast.URL} {
+		org := map[string]string{"id": id, "name": id, "config": webhookConfig(s, id, url, "s3cret")}
+		wantStatus(t, s.do("POST", "/organizations", org), http.StatusOK)
+	}
+	if err := dispatchOutboxBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if waited.Load() {
+		t.Error("beta's webhook waited for acme's")
+	}
+	for _, id := range []string{"acme", "beta"} {
+		if event := outboxEvent(t, id); event.SentAt == nil {
+			t.Errorf("%s's event wasn't sent: %s", id, event.LastError)
+		}
+	}
+}
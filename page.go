@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Page describes the slice of a list requested through the limit, offset
+// and sort query parameters. A zero Limit means no limit.
+type Page struct {
+	Limit  int   `json:"limit"`
+	Offset int   `json:"offset"`
+	Total  int64 `json:"total"`
+
+	// Sort holds the columns of ?sort=name,-id, where a leading "-" sorts
+	// in descending order.
+	Sort []clause.OrderByColumn `json:"-"`
+
+	// After restricts the page to rows whose primary key follows it, for
+	// cursor pagination; NextCursor continues after the page when it is
+	// full.
+	After      string `json:"-"`
+	NextCursor string `json:"next_cursor,omitempty"`
+
+	// Truncated is set when a request without a limit got only the first
+	// Config.UnpaginatedLimit rows.
+	Truncated   bool `json:"truncated,omitempty"`
+	unpaginated bool
+
+	// Links point at the neighbouring pages, see setLinks.
+	Links *PageLinks `json:"links,omitempty"`
+}
+
+// PageLinks are the URLs of the pages around a list page. Prev and Next
+// are left out at the ends of the list, and Last when the total isn't
+// known or the list is paged by cursor.
+type PageLinks struct {
+	First string `json:"first"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+	Last  string `json:"last,omitempty"`
+}
+
+// primaryKeyColumn is the primary key of every listed model. Lists are
+// ordered by it last, so that rows the sort considers equal still come
+// back in the same order on every request.
+const primaryKeyColumn = "id"
+
+// listResponse is the v2 shape of list endpoints.
+type listResponse struct {
+	Data interface{} `json:"data"`
+	Meta Page        `json:"meta"`
+}
+
+// parsePage reads the page parameters. Only the sortable columns may be
+// sorted on, and the limit is held to Config.MaxPageSize, except for
+// streamed lists without one.
+func parsePage(r *http.Request, sortable ...string) (Page, error) {
+	var p Page
+	var err error
+	streamAll := false
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if p.Limit, err = strconv.Atoi(v); err != nil || p.Limit < 0 {
+			return p, errors.New("invalid limit")
+		}
+	} else if wantsStream(r) {
+		// Streamed lists take the same memory however long they are, so
+		// they get every row unless asked for fewer.
+		streamAll = true
+	} else if cfg().UnpaginatedLimit > 0 {
+		p.Limit = cfg().UnpaginatedLimit
+		p.unpaginated = true
+	}
+	if max := cfg().MaxPageSize; max > 0 && !streamAll && (p.Limit == 0 || p.Limit > max) {
+		if p.Limit > max {
+			if cfg().RejectOversizedPages {
+				return p, fmt.Errorf("limit exceeds the maximum of %d", max)
+			}
+			log.Printf("%s %s: clamping limit %d to %d", r.Method, r.URL.Path, p.Limit, max)
+		}
+		p.Limit = max
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if p.Offset, err = strconv.Atoi(v); err != nil || p.Offset < 0 {
+			return p, errors.New("invalid offset")
+		}
+	}
+	if v := r.URL.Query().Get("sort"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			name, desc := strings.CutPrefix(strings.TrimSpace(field), "-")
+			if !slices.Contains(sortable, name) {
+				return p, errors.New("invalid sort field " + name)
+			}
+			p.Sort = append(p.Sort, clause.OrderByColumn{Column: clause.Column{Name: name}, Desc: desc})
+		}
+	}
+	return p, nil
+}
+
+// parseCursor reads the ?cursor parameter of lists paged by primary key.
+// Cursors are opaque to clients and can't be combined with sort or offset.
+func parseCursor(r *http.Request, p *Page) error {
+	v := r.URL.Query().Get("cursor")
+	if v == "" {
+		return nil
+	}
+	key, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || len(key) == 0 {
+		return errors.New("invalid cursor")
+	}
+	if len(p.Sort) > 0 || p.Offset > 0 {
+		return errors.New("cursor can't be combined with sort or offset")
+	}
+	p.After = string(key)
+	return nil
+}
+
+// setNextCursor points NextCursor after lastKey, the primary key of the
+// last row of the page, if the page is full.
+func (p *Page) setNextCursor(rows int, lastKey string) {
+	if p.Limit > 0 && rows == p.Limit {
+		p.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(lastKey))
+	}
+}
+
+// checkTruncated sets Truncated if the n rows listed for a request without
+// a limit aren't all there are; count counts them all.
+func (p *Page) checkTruncated(n int, count func() (int64, error)) error {
+	if !p.unpaginated || n < p.Limit {
+		return nil
+	}
+	total, err := count()
+	if err != nil {
+		return err
+	}
+	p.Truncated = total > int64(n)
+	return nil
+}
+
+// paginate orders a query and restricts it to the page.
+func (p Page) paginate(db *gorm.DB) *gorm.DB {
+	if p.After != "" {
+		db = db.Where(clause.Gt{Column: clause.Column{Name: primaryKeyColumn}, Value: p.After})
+	}
+	db = orderByPrimaryKey(db, p.Sort...)
+	if p.Limit > 0 {
+		db = db.Limit(p.Limit)
+	}
+	if p.Offset > 0 {
+		db = db.Offset(p.Offset)
+	}
+	return db
+}
+
+// orderByPrimaryKey orders a query by the given columns and then by the
+// primary key.
+func orderByPrimaryKey(db *gorm.DB, columns ...clause.OrderByColumn) *gorm.DB {
+	sortedByKey := false
+	for _, c := range columns {
+		db = db.Order(c)
+		sortedByKey = sortedByKey || c.Column.Name == primaryKeyColumn
+	}
+	if !sortedByKey {
+		db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: primaryKeyColumn}})
+	}
+	return db
+}
+
+// setLinks sets Links for a page of the given number of rows, keeping the
+// other query parameters of r. Without a total, there is a next page
+// whenever this one is full.
+func (p *Page) setLinks(r *http.Request, rows int, haveTotal bool) {
+	if p.Limit == 0 {
+		return
+	}
+	link := func(set func(q url.Values)) string {
+		q := r.URL.Query()
+		q.Del("offset")
+		q.Del("cursor")
+		q.Set("limit", strconv.Itoa(p.Limit))
+		set(q)
+		return (&url.URL{Path: r.URL.Path, RawQuery: q.Encode()}).String()
+	}
+	offset := func(n int) func(url.Values) {
+		return func(q url.Values) {
+			if n > 0 {
+				q.Set("offset", strconv.Itoa(n))
+			}
+		}
+	}
+
+	links := &PageLinks{First: link(offset(0))}
+	if r.URL.Query().Has("cursor") {
+		if p.NextCursor != "" {
+			links.Next = link(func(q url.Values) { q.Set("cursor", p.NextCursor) })
+		}
+		p.Links = links
+		return
+	}
+	if p.Offset > 0 {
+		links.Prev = link(offset(max(p.Offset-p.Limit, 0)))
+	}
+	if haveTotal {
+		if int64(p.Offset+p.Limit) < p.Total {
+			links.Next = link(offset(p.Offset + p.Limit))
+		}
+		links.Last = link(offset(int(max(p.Total-1, 0)) / p.Limit * p.Limit))
+	} else if rows == p.Limit {
+		links.Next = link(offset(p.Offset + p.Limit))
+	}
+	p.Links = links
+}
+
+// linkHeader formats links as an RFC 8288 Link header.
+func (l PageLinks) linkHeader() string {
+	var parts []string
+	for _, link := range []struct{ url, rel string }{
+		{l.First, "first"}, {l.Prev, "prev"}, {l.Next, "next"}, {l.Last, "last"},
+	} {
+		if link.url != "" {
+			parts = append(parts, fmt.Sprintf("<%s>; rel=%q", link.url, link.rel))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// wantsTotal reports whether the response reports the page total, which
+// only enveloped lists do.
+func wantsTotal(r *http.Request) bool {
+	return apiVersion(r.Context()) >= apiV2 || cfg().ResponseEnvelope
+}
+
+// writeList encodes list results in the shape of the requested API version:
+// a bare array for v1 and an envelope with pagination metadata for v2 or
+// with Config.ResponseEnvelope. Bare v1 lists carry the next cursor in the
+// X-Next-Cursor header. Every list links to its neighbouring pages in the
+// Link header, and truncated lists are flagged in headers too.
+func writeList(w http.ResponseWriter, r *http.Request, items interface{}, p Page) {
+	p.setLinks(r, reflect.ValueOf(items).Len(), wantsTotal(r))
+	if p.Links != nil {
+		w.Header().Set("Link", p.Links.linkHeader())
+	}
+	if p.Truncated {
+		w.Header().Set("X-Results-Truncated", "true")
+		w.Header().Set("Warning", `299 - "results truncated, page with limit and offset"`)
+	}
+	if apiVersion(r.Context()) < apiV2 && !cfg().ResponseEnvelope {
+		if p.NextCursor != "" {
+			w.Header().Set("X-Next-Cursor", p.NextCursor)
+		}
+		writeJSON(w, r, http.StatusOK, items)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, listResponse{Data: items, Meta: p})
+}
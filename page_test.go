@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestListOrdering(t *testing.T) {
+	s := newTestServer(t)
+	// Inserted out of order, so that insertion order can't pass for it.
+	for _, id := range []string{"delta", "alpha", "charlie", "bravo"} {
+		org := Organization{ID: id, Name: id, Config: s.tenantConfig(id)}
+		if err := centralDB.Create(&org).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, role := range []string{"teacher", "admin", "teacher", "admin", "teacher"} {
+		user := User{Username: fmt.Sprint("user", i), Password: "secret", Role: role}
+		wantStatus(t, s.do("POST", "/users", user), http.StatusOK)
+	}
+
+	organizations := func(path string) []string {
+		rec := s.do("GET", path, nil)
+		wantStatus(t, rec, http.StatusOK)
+		var ids []string
+		for _, org := range decode[[]Organization](t, rec) {
+			ids = append(ids, org.ID)
+		}
+		return ids
+	}
+	users := func(path string) []uint {
+		rec := s.do("GET", path, nil)
+		wantStatus(t, rec, http.StatusOK)
+		var ids []uint
+		for _, user := range decode[[]User](t, rec) {
+			ids = append(ids, user.ID)
+		}
+		return ids
+	}
+
+	for i := 0; i < 3; i++ {
+		if got, want := organizations("/organizations"), []string{"alpha", "bravo", "charlie", "delta"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("organizations = %v, want %v", got, want)
+		}
+		if got, want := organizations("/organizations?limit=2&offset=2"), []string{"charlie", "delta"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("second page = %v, want %v", got, want)
+		}
+		// Ties in the sort are broken by ID, whichever the direction.
+		if got, want := users("/users?sort=role"), []uint{2, 4, 1, 3, 5}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("users by role = %v, want %v", got, want)
+		}
+		if got, want := users("/users?sort=-role"), []uint{1, 3, 5, 2, 4}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("users by descending role = %v, want %v", got, want)
+		}
+		if got, want := users("/users?sort=-id"), []uint{5, 4, 3, 2, 1}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("users by descending ID = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestListCursorPagination(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	for i := 0; i < 5; i++ {
+		if err := s.tenantDB("acme").Create(&Kindergarten{ID: fmt.Sprint("k", i), Name: fmt.Sprint("K", i)}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type v2List struct {
+		Data []Kindergarten `json:"data"`
+		Meta Page           `json:"meta"`
+	}
+	var ids []string
+	path := "/v2/kindergartens?limit=3"
+	for pages := 0; path != ""; pages++ {
+		if pages > 5 {
+			t.Fatal("the cursor doesn't advance")
+		}
+		rec := s.tenant("acme", "GET", path, nil)
+		wantStatus(t, rec, http.StatusOK)
+		list := decode[v2List](t, rec)
+		for _, k := range list.Data {
+			ids = append(ids, k.ID)
+		}
+		// Pages requested by cursor link to the next cursor.
+		if want := "/v2/kindergartens?cursor=" + list.Meta.NextCursor + "&limit=3"; pages > 0 && list.Meta.NextCursor != "" && list.Meta.Links.Next != want {
+			t.Errorf("meta.links = %+v, want next %s", list.Meta.Links, want)
+		}
+		path = ""
+		if list.Meta.NextCursor != "" {
+			path = "/v2/kindergartens?limit=3&cursor=" + list.Meta.NextCursor
+		}
+	}
+	// Provisioning seeds two kindergartens of its own.
+	if len(ids) != 7 || !slices.IsSorted(ids) || len(slices.Compact(slices.Clone(ids))) != 7 {
+		t.Errorf("kindergarten IDs = %v, want 7 in order", ids)
+	}
+	wantStatus(t, s.tenant("acme", "GET", "/v2/kindergartens?sort=name&cursor=MQ", nil), http.StatusBadRequest)
+}
+
+func TestListCursorInterleavedInserts(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	add := func(id string) {
+		t.Helper()
+		if err := s.tenantDB("acme").Create(&Kindergarten{ID: id, Name: id}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"k2", "k4", "k6", "k8"} {
+		add(id)
+	}
+
+	type v2List struct {
+		Data []Kindergarten `json:"data"`
+		Meta Page           `json:"meta"`
+	}
+	var ids []string
+	path := "/v2/kindergartens?limit=2"
+	for pages := 0; path != ""; pages++ {
+		if pages > 10 {
+			t.Fatal("the cursor doesn't advance")
+		}
+		rec := s.tenant("acme", "GET", path, nil)
+		wantStatus(t, rec, http.StatusOK)
+		list := decode[v2List](t, rec)
+		for _, k := range list.Data {
+			ids = append(ids, k.ID)
+		}
+		if pages == 1 {
+			// Behind the cursor and ahead of it.
+			add("k1")
+			add("k9")
+		}
+		path = ""
+		if list.Meta.NextCursor != "" {
+			path = "/v2/kindergartens?limit=2&cursor=" + list.Meta.NextCursor
+		}
+	}
+	// Provisioning seeds kindergartens 1 and 2. Rows inserted behind the
+	// cursor are missed, but none shows twice or shifts another out.
+	if want := []string{"1", "2", "k2", "k4", "k6", "k8", "k9"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("kindergartens = %v, want %v", ids, want)
+	}
+
+	for _, cursor := range []string{"!!!", "%3D%3D"} {
+		wantStatus(t, s.tenant("acme", "GET", "/v2/kindergartens?cursor="+cursor, nil), http.StatusBadRequest)
+	}
+}
+
+func TestMaxPageSize(t *testing.T) {
+	for _, reject := range []bool{false, true} {
+		t.Run(fmt.Sprint("reject ", reject), func(t *testing.T) {
+			s := newTestServer(t, func(c *Config) {
+				c.MaxPageSize = 3
+				c.RejectOversizedPages = reject
+			})
+			for i := 0; i < 5; i++ {
+				user := User{Username: fmt.Sprint("user", i), Password: "secret"}
+				wantStatus(t, s.do("POST", "/users", user), http.StatusOK)
+			}
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			t.Cleanup(func() { log.SetOutput(io.Discard) })
+
+			rec := s.do("GET", "/users?limit=1000000", nil, "Accept", "application/vnd.app.v2+json")
+			if reject {
+				wantStatus(t, rec, http.StatusBadRequest)
+				return
+			}
+			wantStatus(t, rec, http.StatusOK)
+			list := decode[struct {
+				Data []User `json:"data"`
+				Meta Page   `json:"meta"`
+			}](t, rec)
+			if len(list.Data) != 3 || list.Meta.Limit != 3 {
+				t.Errorf("got %d users with limit %d, want 3", len(list.Data), list.Meta.Limit)
+			}
+			if !strings.Contains(logs.String(), "clamping limit 1000000 to 3") {
+				t.Errorf("log %q doesn't report the clamping", logs.String())
+			}
+
+			// Without a limit, the cap is the limit.
+			rec = s.do("GET", "/users", nil)
+			wantStatus(t, rec, http.StatusOK)
+			if got := decode[[]User](t, rec); len(got) != 3 {
+				t.Errorf("got %d users without a limit, want 3", len(got))
+			}
+		})
+	}
+}
+
+func TestUnpaginatedLimit(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.UnpaginatedLimit = 3 })
+	for i := 0; i < 5; i++ {
+		s.addActiveTenant(fmt.Sprint("org", i), "")
+	}
+
+	rec := s.do("GET", "/organizations", nil)
+	wantStatus(t, rec, http.StatusOK)
+	if got := decode[[]Organization](t, rec); len(got) != 3 {
+		t.Errorf("listed %d organizations, want 3", len(got))
+	}
+	if rec.Header().Get("X-Results-Truncated") != "true" || rec.Header().Get("Warning") == "" {
+		t.Errorf("truncation not flagged: %v", rec.Header())
+	}
+	rec = s.do("GET", "/organizations", nil, "Accept", "application/vnd.app.v2+json")
+	if got := decode[listResponse](t, rec).Meta; !got.Truncated {
+		t.Errorf("meta = %+v, want truncated", got)
+	}
+
+	// Asking for a page, even a big one, isn't truncation.
+	rec = s.do("GET", "/organizations?limit=10", nil)
+	wantStatus(t, rec, http.StatusOK)
+	if got := decode[[]Organization](t, rec); len(got) != 5 || rec.Header().Get("X-Results-Truncated") != "" {
+		t.Errorf("listed %d organizations, truncated %q; want 5, not truncated", len(got), rec.Header().Get("X-Results-Truncated"))
+	}
+	// Nor is a list that fits.
+	rec = s.do("GET", "/organizations?status=suspended", nil)
+	if rec.Header().Get("X-Results-Truncated") != "" {
+		t.Error("an empty list is flagged truncated")
+	}
+}
+
+func TestListLinks(t *testing.T) {
+	s := newTestServer(t)
+	for i := 0; i < 5; i++ {
+		wantStatus(t, s.do("POST", "/users", User{Username: fmt.Sprint("user", i), Password: "secret"}), http.StatusOK)
+	}
+
+	for _, tt := range []struct {
+		path string
+		link string
+	}{
+		// Bare lists have no total: next whenever the page is full, no last.
+		{"/users?limit=2&sort=-id", `</users?limit=2&sort=-id>; rel="first", </users?limit=2&offset=2&sort=-id>; rel="next"`},
+		{"/users?limit=2&offset=4", `</users?limit=2>; rel="first", </users?limit=2&offset=2>; rel="prev"`},
+		{"/v2/users?limit=2&offset=2", `</v2/users?limit=2>; rel="first", </v2/users?limit=2>; rel="prev", </v2/users?limit=2&offset=4>; rel="next", </v2/users?limit=2&offset=4>; rel="last"`},
+		{"/v2/users?limit=2&offset=4", `</v2/users?limit=2>; rel="first", </v2/users?limit=2&offset=2>; rel="prev", </v2/users?limit=2&offset=4>; rel="last"`},
+		// Unpaginated lists are held to Config.UnpaginatedLimit.
+		{"/users", `</users?limit=100>; rel="first"`},
+	} {
+		rec := s.do("GET", tt.path, nil)
+		wantStatus(t, rec, http.StatusOK)
+		if got := rec.Header().Get("Link"); got != tt.link {
+			t.Errorf("%s: Link = %s, want %s", tt.path, got, tt.link)
+		}
+	}
+
+	rec := s.do("GET", "/v2/users?limit=2", nil)
+	wantStatus(t, rec, http.StatusOK)
+	links := decode[struct {
+		Meta struct {
+			Links PageLinks `json:"links"`
+		} `json:"meta"`
+	}](t, rec).Meta.Links
+	if want := (PageLinks{First: "/v2/users?limit=2", Next: "/v2/users?limit=2&offset=2", Last: "/v2/users?limit=2&offset=4"}); links != want {
+		t.Errorf("meta.links = %+v, want %+v", links, want)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/subtle"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassword hashes a password with bcrypt at Config.PasswordHashCost.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cfg().PasswordHashCost)
+	return string(hash), err
+}
+
+func isPasswordHash(s string) bool {
+	_, err := bcrypt.Cost([]byte(s))
+	return err == nil
+}
+
+// passwordOutdated reports whether a stored password should be hashed
+// again: it is hashed at a lower cost than configured, or, having been
+// stored before passwords were hashed, not at all.
+func passwordOutdated(stored string) bool {
+	cost, err := bcrypt.Cost([]byte(stored))
+	return err != nil || cost < cfg().PasswordHashCost
+}
+
+// checkPassword compares a password with the stored one, hashed or not.
+func checkPassword(stored, password string) bool {
+	if isPasswordHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordRehashedOnLogin(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	erin, err := userService.Create(ctx, User{Username: "erin", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stored before passwords were hashed.
+	legacy := User{Username: "legacy", Password: "plain"}
+	if err := centralDB.Create(&legacy).Error; err != nil {
+		t.Fatal(err)
+	}
+	c := *cfg()
+	c.PasswordHashCost = bcrypt.MinCost + 1
+	setConfig(&c)
+
+	report := func() PasswordHashReport {
+		t.Helper()
+		rec := s.admin("GET", "/admin/users/password-hashes", nil)
+		wantStatus(t, rec, http.StatusOK)
+		return decode[PasswordHashReport](t, rec)
+	}
+	if got := report(); got != (PasswordHashReport{Users: 2, Outdated: 2}) {
+		t.Errorf("report = %+v, want both outdated", got)
+	}
+
+	for _, login := range []struct {
+		user     User
+		password string
+	}{{erin, "secret"}, {legacy, "plain"}} {
+		wantStatus(t, s.do("POST", "/users/login", map[string]string{"username": login.user.Username, "password": login.password}), http.StatusOK)
+		var stored User
+		if err := centralDB.First(&stored, login.user.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if cost, err := bcrypt.Cost([]byte(stored.Password)); err != nil || cost != c.PasswordHashCost {
+			t.Errorf("%s: hash cost = %d, %v, want %d", login.user.Username, cost, err, c.PasswordHashCost)
+		}
+		// The new hash still checks.
+		wantStatus(t, s.do("POST", "/users/login", map[string]string{"username": login.user.Username, "password": login.password}), http.StatusOK)
+	}
+	if got := report(); got != (PasswordHashReport{Users: 2, Outdated: 0}) {
+		t.Errorf("report = %+v, want none outdated", got)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// ProvisioningJob is an organization created with
+// Config.AsyncProvisioning whose tenant database is waiting to be
+// provisioned. Jobs live in the central database, so those left over when
+// the process stops are picked up by the next one.
+type ProvisioningJob struct {
+	ID             uint   `gorm:"primaryKey"`
+	OrganizationID string `gorm:"index"`
+	Attempts       int
+	LastError      string
+	CreatedAt      time.Time
+	// DoneAt is nil until the organization is provisioned, or gone.
+	DoneAt *time.Time `gorm:"index"`
+}
+
+const (
+	// provisioningMaxAttempts is the number of provisioning attempts after
+	// which a job is left with its LastError, and the organization in
+	// StatusProvisioning, for operators to look at.
+	provisioningMaxAttempts = 5
+	provisioningBatchSize   = 10
+	provisioningInterval    = 5 * time.Second
+)
+
+// provisioningWake nudges provisionTenants after a job was committed, so it
+// doesn't wait for its next round.
+var provisioningWake = make(chan struct{}, 1)
+
+// wakeProvisioner tells provisionTenants that jobs are waiting.
+func wakeProvisioner() {
+	select {
+	case provisioningWake <- struct{}{}:
+	default:
+	}
+}
+
+// provisionTenants runs provisioning jobs one at a time until ctx is done,
+// finishing the batch under way.
+func provisionTenants(ctx context.Context) {
+	ticker := time.NewTicker(provisioningInterval)
+	defer ticker.Stop()
+	for {
+		if err := runProvisioningJobs(); err != nil {
+			log.Printf("provisioning: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-provisioningWake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func runProvisioningJobs() error {
+	var jobs []ProvisioningJob
+	err := centralDB.Where("done_at IS NULL AND attempts < ?", provisioningMaxAttempts).
+		Order("id").Limit(provisioningBatchSize).Find(&jobs).Error
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		runProvisioningJob(job)
+	}
+	return nil
+}
+
+// runProvisioningJob provisions the job's organization and activates it,
+// as OrganizationService.Create does.
+func runProvisioningJob(job ProvisioningJob) {
+	ctx := context.Background()
+	org, err := organizationService.Get(ctx, job.OrganizationID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		log.Printf("tenant %s: dropping provisioning job %d: organization is gone", job.OrganizationID, job.ID)
+		err = nil
+	case err == nil:
+		var already bool
+		already, err = organizationService.provision(ctx, org, true)
+		if already {
+			log.Printf("tenant %s: database was already provisioned", org.ID)
+		}
+		if err == nil {
+			err = organizationService.activate(ctx, org)
+		}
+	}
+
+	updates := map[string]interface{}{"attempts": job.Attempts + 1}
+	if err != nil {
+		log.Printf("tenant %s: provisioning failed: %v", job.OrganizationID, err)
+		updates["last_error"] = err.Error()
+	} else {
+		updates["done_at"] = time.Now()
+	}
+	if err := centralDB.Model(&job).Updates(updates).Error; err != nil {
+		log.Printf("provisioning: could not update job %d: %v", job.ID, err)
+	}
+}
+
+// ProvisioningStatus reports how far an organization's provisioning got.
+// Attempts and LastError come from its latest ProvisioningJob, and are
+// zero for organizations provisioned synchronously. Failed is set once the
+// job has given up.
+type ProvisioningStatus struct {
+	OrganizationID string             `json:"organization_id"`
+	Status         OrganizationStatus `json:"status"`
+	Done           bool               `json:"done"`
+	Failed         bool               `json:"failed,omitempty"`
+	Attempts       int                `json:"attempts"`
+	LastError      string             `json:"last_error,omitempty"`
+}
+
+func (s *OrganizationService) ProvisioningStatus(ctx context.Context, id string) (ProvisioningStatus, error) {
+	org, err := s.Get(ctx, id)
+	if err != nil {
+		return ProvisioningStatus{}, err
+	}
+	status := ProvisioningStatus{OrganizationID: org.ID, Status: org.Status, Done: org.Status != StatusProvisioning}
+
+	var job ProvisioningJob
+	err = s.db.WithContext(ctx).Where("organization_id = ?", id).Order("id DESC").First(&job).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return status, nil
+	case err != nil:
+		return status, newError(nil, "could not get provisioning status", err)
+	}
+	status.Attempts = job.Attempts
+	status.LastError = job.LastError
+	status.Failed = job.DoneAt == nil && job.Attempts >= provisioningMaxAttempts
+	return status, nil
+}
+
+func getProvisioningStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := organizationService.ProvisioningStatus(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, status)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestAsyncProvisioning(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.AsyncProvisioning = true })
+	status := func(id string) ProvisioningStatus {
+		t.Helper()
+		rec := s.do("GET", "/organizations/"+id+"/provisioning", nil)
+		wantStatus(t, rec, http.StatusOK)
+		return decode[ProvisioningStatus](t, rec)
+	}
+
+	rec := s.do("POST", "/organizations", Organization{ID: "acme", Name: "Acme", Config: s.tenantConfig("acme")})
+	wantStatus(t, rec, http.StatusAccepted)
+	if got := rec.Header().Get("Location"); got != "/organizations/acme/provisioning" {
+		t.Errorf("Location = %q", got)
+	}
+	if got := status("acme"); got.Status != StatusProvisioning || got.Done || got.Attempts != 0 {
+		t.Errorf("queued status = %+v", got)
+	}
+	wantStatus(t, s.tenant("acme", "GET", "/settings", nil), http.StatusServiceUnavailable)
+
+	if err := runProvisioningJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if got := status("acme"); got.Status != StatusActive || !got.Done || got.Attempts != 1 {
+		t.Errorf("provisioned status = %+v", got)
+	}
+	wantStatus(t, s.tenant("acme", "GET", "/settings", nil), http.StatusOK)
+	wantStatus(t, s.do("GET", "/organizations/gone/provisioning", nil), http.StatusNotFound)
+}
+
+func TestAsyncProvisioningGivesUp(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.AsyncProvisioning = true })
+	// SQLite doesn't create missing directories.
+	config, _ := json.Marshal(map[string]string{"dsn": filepath.Join(s.dir, "missing", "acme.db")})
+	wantStatus(t, s.do("POST", "/organizations", Organization{ID: "acme", Name: "Acme", Config: string(config)}), http.StatusAccepted)
+
+	for i := 0; i < provisioningMaxAttempts+1; i++ {
+		if err := runProvisioningJobs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := decode[ProvisioningStatus](t, s.do("GET", "/organizations/acme/provisioning", nil))
+	if !got.Failed || got.Done || got.Attempts != provisioningMaxAttempts || got.LastError == "" {
+		t.Errorf("status = %+v, want failed after %d attempts", got, provisioningMaxAttempts)
+	}
+}
+
+func TestAsyncProvisioningKeepsArchived(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.AsyncProvisioning = true })
+	wantStatus(t, s.do("POST", "/organizations", Organization{ID: "acme", Name: "Acme", Config: s.tenantConfig("acme")}), http.StatusAccepted)
+	org := decode[Organization](t, s.do("GET", "/organizations/acme", nil))
+	org.Status = StatusArchived
+	wantStatus(t, s.do("PUT", "/organizations/acme", org), http.StatusOK)
+
+	if err := runProvisioningJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if got := decode[Organization](t, s.do("GET", "/organizations/acme", nil)); got.Status != StatusArchived {
+		t.Errorf("status = %s, want %s", got.Status, StatusArchived)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// purgeableModels are the tenant models deleted softly, with the setting
+// holding how long their deleted rows are kept.
+var purgeableModels = []struct {
+	table     string
+	model     interface{}
+	retention func(*Config) time.Duration
+}{
+	{"kindergartens", &Kindergarten{}, func(c *Config) time.Duration { return c.KindergartenRetention }},
+}
+
+// purgeIdleCheck is how often purgeDeletedRecords looks at the config
+// again while purging is turned off.
+const purgeIdleCheck = time.Minute
+
+// purgeDeletedRecords runs purgeTenants every Config.PurgeInterval until
+// ctx is done. A purge that has started runs to the end.
+func purgeDeletedRecords(ctx context.Context) {
+	for {
+		interval := cfg().PurgeInterval
+		if interval <= 0 {
+			if !sleep(ctx, purgeIdleCheck) {
+				return
+			}
+			continue
+		}
+		purgeTenants(context.Background(), time.Now())
+		if !sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+// purgeTenants deletes for good the rows of purgeableModels deleted softly
+// longer ago than their retention, as of now, in every tenant database.
+// Models whose retention is zero keep their deleted rows. Tenants still
+// being provisioned, or whose database fails, are skipped until the next
+// run.
+func purgeTenants(ctx context.Context, now time.Time) {
+	c := cfg()
+	var enabled bool
+	for _, m := range purgeableModels {
+		enabled = enabled || m.retention(c) > 0
+	}
+	if !enabled {
+		return
+	}
+
+	var organizations []Organization
+	if err := centralDB.WithContext(ctx).Where("status <> ?", StatusProvisioning).Find(&organizations).Error; err != nil {
+		log.Printf("purge: could not list organizations: %v", err)
+		return
+	}
+	purged := map[string]int64{}
+	for _, org := range organizations {
+		tc, err := parseTenantConfig(org.ID, org.Config)
+		if errors.Is(err, errTenantNotConfigured) {
+			continue
+		}
+		if err != nil {
+			log.Printf("tenant %s: purge: invalid tenant config: %v", org.ID, err)
+			continue
+		}
+		db, err := getTenantDB(ctx, tc)
+		if err != nil {
+			log.Printf("tenant %s: purge: %v", org.ID, err)
+			continue
+		}
+		for _, m := range purgeableModels {
+			retention := m.retention(c)
+			if retention <= 0 {
+				continue
+			}
+			res := db.WithContext(ctx).Unscoped().Where("deleted_at < ?", now.Add(-retention)).Delete(m.model)
+			if res.Error != nil {
+				log.Printf("tenant %s: purge: could not purge %s: %v", org.ID, m.table, res.Error)
+				continue
+			}
+			purged[m.table] += res.RowsAffected
+		}
+	}
+	for _, m := range purgeableModels {
+		if m.retention(c) > 0 {
+			log.Printf("purge: %d %s deleted more than %s ago purged across %d tenants", purged[m.table], m.table, m.retention(c), len(organizations))
+		}
+	}
+}
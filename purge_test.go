@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestPurgeTenants(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.KindergartenRetention = time.Hour })
+	s.createTenant("acme")
+	for _, id := range []string{"old", "recent", "kept"} {
+		wantStatus(t, s.tenant("acme", "POST", "/kindergartens", Kindergarten{ID: id, Name: "Kindergarten " + id}), http.StatusCreated)
+	}
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens/old/classrooms", Classroom{Name: "Daisies"}), http.StatusCreated)
+	now := time.Now().UTC()
+	db := s.tenantDB("acme")
+	for id, deleted := range map[string]time.Time{"old": now.Add(-2 * time.Hour), "recent": now.Add(-30 * time.Minute)} {
+		if err := db.Model(&Kindergarten{ID: id}).Update("deleted_at", deleted).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	remaining := func() map[string]bool {
+		t.Helper()
+		var ids []string
+		if err := db.Unscoped().Model(&Kindergarten{}).Pluck("id", &ids).Error; err != nil {
+			t.Fatal(err)
+		}
+		m := map[string]bool{}
+		for _, id := range ids {
+			m[id] = true
+		}
+		return m
+	}
+
+	purgeTenants(context.Background(), now)
+	if left := remaining(); left["old"] || !left["recent"] || !left["kept"] {
+		t.Errorf("left %v, want old purged and the others kept", left)
+	}
+	var classrooms int64
+	db.Model(&Classroom{}).Count(&classrooms)
+	if classrooms != 0 {
+		t.Errorf("%d classrooms left of the purged kindergarten", classrooms)
+	}
+
+	// An hour later the recent one is past its retention too.
+	purgeTenants(context.Background(), now.Add(time.Hour))
+	if left := remaining(); left["recent"] || !left["kept"] {
+		t.Errorf("left %v, want only kept", left)
+	}
+}
+
+func TestPurgedSeedStaysGone(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.KindergartenRetention = time.Hour })
+	s.createTenant("acme")
+	wantStatus(t, s.tenant("acme", "DELETE", "/kindergartens/1", nil), http.StatusNoContent)
+	deleted := time.Now().UTC()
+	purgeTenants(context.Background(), deleted.Add(2*time.Hour))
+
+	for _, path := range []string{"/kindergartens", "/kindergartens?since=" + deleted.Add(-time.Minute).Format(time.RFC3339)} {
+		rec := s.tenant("acme", "GET", path, nil)
+		wantStatus(t, rec, http.StatusOK)
+		for _, k := range decode[[]Kindergarten](t, rec) {
+			if k.ID == "1" {
+				t.Errorf("%s lists the purged kindergarten: %+v", path, k)
+			}
+		}
+	}
+	err := s.tenantDB("acme").Unscoped().First(&Kindergarten{}, "id = ?", "1").Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("purged kindergarten is back: %v", err)
+	}
+}
+
+func TestPurgeKeepsWithoutRetention(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens", Kindergarten{ID: "k1", Name: "Sunflower"}), http.StatusCreated)
+	wantStatus(t, s.tenant("acme", "DELETE", "/kindergartens/k1", nil), http.StatusNoContent)
+
+	purgeTenants(context.Background(), time.Now().Add(24*365*time.Hour))
+	err := s.tenantDB("acme").Unscoped().First(&Kindergarten{}, "id = ?", "k1").Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Error("deleted kindergarten purged without a retention")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+const queryCountKey contextKey = "queryCount"
+
+// registerQueryCounter makes db count the statements it runs into the
+// counter of their context, if it has one. Queries only count when they
+// are made with WithContext(r.Context()).
+func registerQueryCounter(db *gorm.DB) error {
+	return afterEachStatement(db, "count", func(tx *gorm.DB) {
+		if n, ok := tx.Statement.Context.Value(queryCountKey).(*atomic.Int64); ok {
+			n.Add(1)
+		}
+	})
+}
+
+// afterEachStatement registers fn to run after every kind of statement db
+// runs, under callback names prefixed with name.
+func afterEachStatement(db *gorm.DB, name string, fn func(*gorm.DB)) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().After("gorm:create").Register("mtgo:"+name+"_create", fn),
+		cb.Query().After("gorm:query").Register("mtgo:"+name+"_query", fn),
+		cb.Update().After("gorm:update").Register("mtgo:"+name+"_update", fn),
+		cb.Delete().After("gorm:delete").Register("mtgo:"+name+"_delete", fn),
+		cb.Row().After("gorm:row").Register("mtgo:"+name+"_row", fn),
+		cb.Raw().After("gorm:raw").Register("mtgo:"+name+"_raw", fn),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountQueries counts the SQL statements each request runs. It logs the
+// requests running more than Config.QueryCountThreshold, which usually
+// means a query in a loop, and in Config.Debug reports the count in the
+// X-Query-Count header. It does nothing when neither is on.
+func CountQueries(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := cfg()
+		if c.QueryCountThreshold <= 0 && !c.Debug {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		n := new(atomic.Int64)
+		if c.Debug {
+			w = &queryCountWriter{ResponseWriter: w, n: n}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), queryCountKey, n)))
+
+		if c.QueryCountThreshold > 0 && n.Load() > int64(c.QueryCountThreshold) {
+			route := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			log.Printf("%s %s ran %d queries, more than %d", r.Method, route, n.Load(), c.QueryCountThreshold)
+		}
+	})
+}
+
+// queryCountWriter sets X-Query-Count as the response starts. Queries run
+// after that, while streaming the body, aren't in it.
+type queryCountWriter struct {
+	http.ResponseWriter
+	n           *atomic.Int64
+	wroteHeader bool
+}
+
+func (w *queryCountWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("X-Query-Count", strconv.FormatInt(w.n.Load(), 10))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *queryCountWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *queryCountWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestQueryCountHeader(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.Debug = true })
+	count := func() int {
+		t.Helper()
+		rec := s.do("GET", "/organizations", nil)
+		wantStatus(t, rec, http.StatusOK)
+		n, err := strconv.Atoi(rec.Header().Get("X-Query-Count"))
+		if err != nil {
+			t.Fatalf("X-Query-Count = %q: %v", rec.Header().Get("X-Query-Count"), err)
+		}
+		return n
+	}
+
+	s.createTenant("acme")
+	one := count()
+	s.createTenant("beta")
+	s.createTenant("gamma")
+	// Listing organizations queries every tenant database.
+	if three := count(); three != one+2 {
+		t.Errorf("queries with three tenants = %d, want %d", three, one+2)
+	}
+}
+
+func TestQueryCountThreshold(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.QueryCountThreshold = 1 })
+	s.createTenant("acme")
+	s.createTenant("beta")
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+
+	rec := s.do("GET", "/organizations", nil)
+	wantStatus(t, rec, http.StatusOK)
+	if rec.Header().Get("X-Query-Count") != "" {
+		t.Error("X-Query-Count sent outside debug mode")
+	}
+	if !strings.Contains(buf.String(), "GET /organizations") || !strings.Contains(buf.String(), "more than 1") {
+		t.Errorf("log %q doesn't report the request", buf.String())
+	}
+
+	buf.Reset()
+	wantStatus(t, s.do("GET", "/livez", nil), http.StatusOK)
+	if strings.Contains(buf.String(), "queries") {
+		t.Errorf("log %q reports a request under the threshold", buf.String())
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// registerQueryTag makes db tag the statements it logs with the database
+// they ran on, named name, and the tenant of their context, such as
+// "/* db=central tenant=acme */". A handler querying the wrong database for
+// its tenant then shows in the log.
+//
+// The tag is added once the statement has run, so the database never sees
+// it; gorm logs the statement afterwards.
+func registerQueryTag(name string) func(*gorm.DB) error {
+	// The query of a DSN may hold a '?', which gorm would take for a
+	// placeholder when it fills in the parameters.
+	name, _, _ = strings.Cut(redactDSN(name, name), "?")
+	return func(db *gorm.DB) error {
+		return afterEachStatement(db, "tag", func(tx *gorm.DB) {
+			sql := tx.Statement.SQL.String()
+			if sql == "" {
+				return
+			}
+			tenant := tenantID(tx.Statement.Context)
+			if tenant == "" {
+				tenant = "-"
+			}
+			tx.Statement.SQL.Reset()
+			tx.Statement.SQL.WriteString("/* db=" + name + " tenant=" + tenant + " */ " + sql)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestQueryTags(t *testing.T) {
+	for _, redact := range []bool{true, false} {
+		t.Run(fmt.Sprint("redact ", redact), func(t *testing.T) {
+			s := newTestServer(t, func(c *Config) { c.RedactQueryLogs = redact })
+			// The '?' of the DSN's query must not be taken for a placeholder.
+			config, _ := json.Marshal(map[string]string{"dsn": s.tenantDSN("acme") + "?_busy_timeout=5000"})
+			wantStatus(t, s.do("POST", "/organizations", Organization{ID: "acme", Name: "Acme", Config: string(config)}), http.StatusOK)
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			t.Cleanup(func() { log.SetOutput(io.Discard) })
+
+			// Missing rows are logged.
+			wantStatus(t, s.tenant("acme", "GET", "/kindergartens/missing", nil), http.StatusNotFound)
+			wantStatus(t, s.tenant("gone", "GET", "/kindergartens", nil), http.StatusBadRequest)
+
+			for _, tag := range []string{
+				"/* db=" + s.tenantDSN("acme") + " tenant=acme */ SELECT",
+				"/* db=central tenant=- */ SELECT",
+			} {
+				if !strings.Contains(logs.String(), tag) {
+					t.Errorf("log %q has no %q", logs.String(), tag)
+				}
+			}
+			if strings.Contains(logs.String(), "_busy_timeout") {
+				t.Errorf("log %q has the DSN's query", logs.String())
+			}
+			if got := strings.Contains(logs.String(), `"missing"`); got == redact {
+				t.Errorf("log %q: has the parameter = %v", logs.String(), got)
+			}
+		})
+	}
+}
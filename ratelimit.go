@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// rateLimitStore keeps the token buckets rate limits are enforced with.
+// Buckets hold up to burst tokens and refill at rate tokens per second;
+// each request takes one.
+type rateLimitStore interface {
+	take(key string, rate float64, burst int, now time.Time) (rateLimitState, error)
+}
+
+// rateLimiter keeps the buckets in memory, which limits each instance on
+// its own.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitState is a bucket as reported to clients.
+type rateLimitState struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again, and RetryAfter
+	// the time until the next request is allowed.
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*bucket{}}
+}
+
+func (l *rateLimiter) take(key string, rate float64, burst int, now time.Time) (rateLimitState, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.pruned) > time.Minute {
+		l.prune(rate, burst, now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	return b.take(rate, burst, now), nil
+}
+
+// prune forgets the buckets that have refilled, which are no different
+// from new ones, so that keys that stop coming don't pile up.
+func (l *rateLimiter) prune(rate float64, burst int, now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.pruned = now
+}
+
+// rename moves the bucket of key to newKey.
+func (l *rateLimiter) rename(key, newKey string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		l.buckets[newKey] = b
+		delete(l.buckets, key)
+	}
+}
+
+// take refills the bucket up to now and takes a token from it.
+func (b *bucket) take(rate float64, burst int, now time.Time) rateLimitState {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	s := rateLimitState{Limit: burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		s.Allowed = true
+	} else {
+		s.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+	s.Remaining = int(b.tokens)
+	s.Reset = secondsToDuration((float64(burst) - b.tokens) / rate)
+	return s
+}
+
+// RateLimitBucket is a token bucket shared by all instances through the
+// central database.
+type RateLimitBucket struct {
+	ID     string `gorm:"primaryKey"`
+	Tokens float64
+	Last   time.Time
+}
+
+// dbRateLimiter keeps the buckets in the central database, so that the
+// limits hold across instances.
+type dbRateLimiter struct{}
+
+// dbRateLimitAttempts bounds how many times dbRateLimiter reads a bucket
+// again after another request changed it under it.
+const dbRateLimitAttempts = 10
+
+// take creates the bucket if it is missing, then takes a token with an
+// update that only applies if the bucket is still as it was read, and
+// reads it again otherwise. Unlike locking it with FOR UPDATE, which
+// SQLite ignores, that holds on every driver.
+func (dbRateLimiter) take(key string, rate float64, burst int, now time.Time) (rateLimitState, error) {
+	for attempt := 0; attempt < dbRateLimitAttempts; attempt++ {
+		var row RateLimitBucket
+		err := centralDB.First(&row, "id = ?", key).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Another request may be creating it too; either one will do.
+			row = RateLimitBucket{ID: key, Tokens: float64(burst), Last: now}
+			if err := centralDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+				return rateLimitState{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return rateLimitState{}, err
+		}
+
+		b := bucket{tokens: row.Tokens, last: row.Last}
+		s := b.take(rate, burst, now)
+		res := centralDB.Model(&RateLimitBucket{}).
+			Where("id = ? AND tokens = ? AND last = ?", key, row.Tokens, row.Last).
+			Updates(map[string]interface{}{"tokens": b.tokens, "last": b.last})
+		if res.Error != nil {
+			return rateLimitState{}, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return s, nil
+		}
+	}
+	return rateLimitState{}, errors.New("rate limit bucket kept changing")
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+var (
+	tenantRateLimiter   = newRateLimiter()
+	tenantDBRateLimiter dbRateLimiter
+
+	// ipRateLimiter is always in memory: per-IP limits are about
+	// shielding each instance.
+	ipRateLimiter = newRateLimiter()
+)
+
+// rateLimitBackend returns the store selected by Config.RateLimitBackend.
+func rateLimitBackend() rateLimitStore {
+	if cfg().RateLimitBackend == "database" {
+		return tenantDBRateLimiter
+	}
+	return tenantRateLimiter
+}
+
+// TenantRateLimit limits the request rate of each tenant and reports the
+// state of the tenant's bucket in X-RateLimit-* headers. It must run after
+// TenantMiddleware. When the bucket can't be read, requests are let
+// through or refused according to Config.RateLimitFailOpen.
+func TenantRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := cfg()
+		if c.RateLimitRPS <= 0 || c.RateLimitBurst <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s, err := rateLimitBackend().take(tenantID(r.Context()), c.RateLimitRPS, c.RateLimitBurst, time.Now())
+		if err != nil {
+			log.Printf("tenant %s: rate limiter: %v", tenantID(r.Context()), err)
+			if c.RateLimitFailOpen {
+				next.ServeHTTP(w, r)
+			} else {
+				httpError(w, "rate limiter unavailable", http.StatusServiceUnavailable)
+			}
+			return
+		}
+		if writeRateLimit(w, s) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// IPRateLimit limits the request rate of each client IP, as resolved by
+// RealIP, on the routes TenantRateLimit doesn't cover.
+func IPRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := cfg()
+		if c.IPRateLimitRPS <= 0 || c.IPRateLimitBurst <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s, _ := ipRateLimiter.take(clientIP(r.Context()), c.IPRateLimitRPS, c.IPRateLimitBurst, time.Now())
+		if writeRateLimit(w, s) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// writeRateLimit reports the state of a bucket in X-RateLimit-* headers
+// and, if the request isn't allowed, answers 429. It reports whether the
+// request may go on.
+func writeRateLimit(w http.ResponseWriter, s rateLimitState) bool {
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(s.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(s.Remaining))
+	h.Set("X-RateLimit-Reset", ceilSeconds(s.Reset))
+	if !s.Allowed {
+		h.Set("Retry-After", ceilSeconds(s.RetryAfter))
+		httpError(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
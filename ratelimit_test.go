@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTenantRateLimitHeaders(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		// Slow enough not to refill during the test.
+		c.RateLimitRPS = 0.01
+		c.RateLimitBurst = 3
+	})
+	s.createTenant("acme")
+	s.createTenant("beta")
+
+	for want := 2; want >= 0; want-- {
+		rec := s.tenant("acme", "GET", "/kindergartens", nil)
+		wantStatus(t, rec, http.StatusOK)
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("X-RateLimit-Limit = %q, want 3", got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != strconv.Itoa(want) {
+			t.Errorf("X-RateLimit-Remaining = %q, want %d", got, want)
+		}
+		if reset, _ := strconv.Atoi(rec.Header().Get("X-RateLimit-Reset")); reset <= 0 {
+			t.Errorf("X-RateLimit-Reset = %q", rec.Header().Get("X-RateLimit-Reset"))
+		}
+	}
+
+	rec := s.tenant("acme", "GET", "/kindergartens", nil)
+	wantStatus(t, rec, http.StatusTooManyRequests)
+	retry, _ := strconv.Atoi(rec.Header().Get("Retry-After"))
+	// One token comes back every 100s.
+	if retry <= 0 || retry > 100 || rec.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("Retry-After = %q with %q remaining", rec.Header().Get("Retry-After"), rec.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	// Each tenant has a bucket of its own.
+	rec = s.tenant("beta", "GET", "/kindergartens", nil)
+	wantStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "2" {
+		t.Errorf("beta: X-RateLimit-Remaining = %q, want 2", got)
+	}
+}
+
+func TestDBRateLimiterConcurrentTakes(t *testing.T) {
+	newTestServer(t)
+	const burst, requests = 10, 30
+	now := time.Now()
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// No refill, so only the burst gets through.
+			s, err := tenantDBRateLimiter.take("acme", 1e-9, burst, now)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if s.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := allowed.Load(); got != burst {
+		t.Errorf("%d requests allowed, want %d", got, burst)
+	}
+}
+
+func TestIPRateLimit(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		c.IPRateLimitRPS = 0.01
+		c.IPRateLimitBurst = 2
+		// httptest requests come from 192.0.2.1.
+		_, proxies, _ := net.ParseCIDR("192.0.2.0/24")
+		c.TrustedProxies = []*net.IPNet{proxies}
+	})
+	badToken := func(ip string) *httptest.ResponseRecorder {
+		return s.do("GET", "/admin/stats", nil, "Authorization", "Bearer wrong", "X-Forwarded-For", ip)
+	}
+
+	for i := 0; i < 2; i++ {
+		wantStatus(t, badToken("198.51.100.1"), http.StatusUnauthorized)
+	}
+	rec := badToken("198.51.100.1")
+	wantStatus(t, rec, http.StatusTooManyRequests)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+	// The organization routes share the client's bucket.
+	wantStatus(t, s.do("GET", "/organizations", nil, "X-Forwarded-For", "198.51.100.1"), http.StatusTooManyRequests)
+	// Other clients have buckets of their own.
+	wantStatus(t, badToken("198.51.100.2"), http.StatusUnauthorized)
+}
+
+func TestRateLimiterPrunesFullBuckets(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Now()
+	for _, key := range []string{"a", "b"} {
+		l.take(key, 1, 10, now)
+	}
+	// Past the prune interval, the buckets have refilled.
+	l.take("c", 1, 10, now.Add(2*time.Minute))
+	if _, ok := l.buckets["a"]; ok || len(l.buckets) != 1 {
+		t.Errorf("buckets = %v, want only c", l.buckets)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	newTestServer(t, func(c *Config) {
+		_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+		c.TrustedProxies = []*net.IPNet{proxies}
+	})
+
+	for _, tt := range []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		realIP     string
+		want       string
+	}{
+		{"direct", "203.0.113.7:1234", "", "", "203.0.113.7"},
+		{"untrusted peer forwarding", "203.0.113.7:1234", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"spoofed hop before the client", "10.0.0.1:1234", "1.2.3.4, 198.51.100.1", "", "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "", "198.51.100.1"},
+		{"X-Real-IP from a trusted proxy", "10.0.0.1:1234", "", "198.51.100.2", "198.51.100.2"},
+		{"garbage forwarded", "10.0.0.1:1234", "not-an-ip", "", "10.0.0.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r.Context())
+				if r.RemoteAddr != got {
+					t.Errorf("RemoteAddr = %q, want %q", r.RemoteAddr, got)
+				}
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRenameOrganization(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	s.createTenant("beta")
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens", Kindergarten{ID: "k1", Name: "K1"}), http.StatusCreated)
+	key := s.mintKey("acme", "kindergartens:read")
+	// Cached under the old ID.
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens/k1", nil), http.StatusOK)
+
+	rec := s.admin("POST", "/admin/organizations/acme/rename", OrganizationRename{ID: "acme-corp"})
+	wantStatus(t, rec, http.StatusOK)
+	if got := decode[Organization](t, rec); got.ID != "acme-corp" {
+		t.Errorf("renamed organization = %+v", got)
+	}
+
+	wantStatus(t, s.do("GET", "/organizations/acme", nil), http.StatusNotFound)
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens/k1", nil), http.StatusBadRequest)
+	wantStatus(t, s.tenant("acme-corp", "GET", "/kindergartens/k1", nil), http.StatusOK)
+	wantStatus(t, s.do("GET", "/kindergartens/k1", nil, "Authorization", "ApiKey "+key), http.StatusOK)
+
+	wantStatus(t, s.admin("POST", "/admin/organizations/beta/rename", OrganizationRename{ID: "acme-corp"}), http.StatusConflict)
+	wantStatus(t, s.tenant("beta", "GET", "/settings", nil), http.StatusOK)
+	wantStatus(t, s.admin("POST", "/admin/organizations/gone/rename", OrganizationRename{ID: "other"}), http.StatusNotFound)
+	wantStatus(t, s.admin("POST", "/admin/organizations/beta/rename", OrganizationRename{}), http.StatusUnprocessableEntity)
+	wantStatus(t, s.admin("POST", "/admin/organizations/beta/rename", OrganizationRename{ID: "no spaces"}), http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestTenantReplica(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	// The replica has data of its own, to tell which database was read.
+	replica, err := openTenantDB(ctx, s.tenantDSN("acme-replica"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runTenantMigrations(replica, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.Create(&Kindergarten{ID: "r1", Name: "Replicated"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	closeDB(replica)
+
+	config, _ := json.Marshal(map[string]string{"dsn": s.tenantDSN("acme"), "replica_dsn": s.tenantDSN("acme-replica")})
+	wantStatus(t, s.do("POST", "/organizations", Organization{ID: "acme", Name: "Acme", Config: string(config)}), http.StatusOK)
+	if err := s.tenantDB("acme").Create(&Kindergarten{ID: "k1", Name: "Primary"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rec := s.do("GET", "/organizations", nil)
+	wantStatus(t, rec, http.StatusOK)
+	orgs := decode[[]Organization](t, rec)
+	if len(orgs) != 1 || len(orgs[0].Kindergartens) != 1 || orgs[0].Kindergartens[0].ID != "r1" {
+		t.Errorf("organizations = %+v, want the kindergartens of the replica", orgs)
+	}
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens/k1", nil), http.StatusOK)
+
+	tc, err := parseTenantConfig("acme", string(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := getTenantReplicaDB(ctx, tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateTenantOnce(tc.replica(), db); !errors.Is(err, errReadOnlyTenantDB) {
+		t.Errorf("migrating the replica: err = %v, want %v", err, errReadOnlyTenantDB)
+	}
+	if err := db.Exec("DROP TABLE classrooms").Error; !errors.Is(err, errReadOnlyTenantDB) {
+		t.Errorf("dropping a table on the replica: err = %v, want %v", err, errReadOnlyTenantDB)
+	}
+	if !db.Migrator().HasTable(&Classroom{}) {
+		t.Error("classrooms dropped from the replica")
+	}
+}
+
+func TestTenantReplicaNotMigrated(t *testing.T) {
+	s := newTestServer(t)
+	tc := TenantConfig{DSN: s.tenantDSN("acme"), ReplicaDSN: s.tenantDSN("acme-replica")}
+	if err := os.WriteFile(tc.ReplicaDSN, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// An unmigrated replica is reported, not migrated.
+	if _, err := getTenantReplicaDB(context.Background(), tc); !errors.Is(err, errTenantNotMigrated) {
+		t.Errorf("err = %v, want %v", err, errTenantNotMigrated)
+	}
+	db, err := openTenantDB(context.Background(), tc.ReplicaDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB(db)
+	if db.Migrator().HasTable(&Kindergarten{}) {
+		t.Error("opening the replica migrated it")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// jsonContentType is the Content-Type of every JSON response. The charset
+// is spelled out for clients that assume ISO-8859-1 without one.
+const jsonContentType = "application/json; charset=utf-8"
+
+// envelope and errorEnvelope wrap response bodies when
+// Config.ResponseEnvelope is set. Lists have their own envelope,
+// listResponse.
+type envelope struct {
+	Data interface{} `json:"data"`
+}
+
+type errorEnvelope struct {
+	Error string `json:"error"`
+	// Field is the path of the request field at fault, see Error.Field.
+	Field string `json:"field,omitempty"`
+}
+
+// writeJSON writes v as the JSON response body with the given status. The
+// body is encoded into a buffer first, so that a value failing to encode
+// still produces a clean 500 rather than a truncated response. ?pretty=true
+// indents the body for reading in a browser.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	if _, ok := v.(listResponse); !ok && cfg().ResponseEnvelope {
+		v = envelope{Data: v}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		log.Printf("%s %s: could not encode response: %v", r.Method, r.URL.Path, err)
+		httpError(w, "could not encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("%s %s: could not write response: %v", r.Method, r.URL.Path, err)
+	}
+}
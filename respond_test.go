@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteJSONEncodingFailure(t *testing.T) {
+	newTestServer(t)
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+
+	rec := httptest.NewRecorder()
+	writeJSON(rec, httptest.NewRequest("GET", "/organizations", nil), http.StatusOK, map[string]interface{}{"ch": make(chan int)})
+
+	wantStatus(t, rec, http.StatusInternalServerError)
+	if body := rec.Body.String(); strings.Contains(body, `"ch"`) {
+		t.Errorf("body %q has part of the value", body)
+	}
+	if got := logs.String(); !strings.Contains(got, "GET /organizations: could not encode response") {
+		t.Errorf("log %q doesn't report the failure", got)
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+
+	compact := s.do("GET", "/organizations/acme", nil)
+	pretty := s.do("GET", "/organizations/acme?pretty=true", nil)
+	wantStatus(t, compact, http.StatusOK)
+	wantStatus(t, pretty, http.StatusOK)
+
+	if strings.Contains(strings.TrimSpace(compact.Body.String()), "\n") {
+		t.Errorf("compact body %q has line breaks", compact.Body.String())
+	}
+	if !strings.Contains(pretty.Body.String(), "\n  \"id\": \"acme\"") {
+		t.Errorf("pretty body %q isn't indented", pretty.Body.String())
+	}
+	var a, b bytes.Buffer
+	json.Compact(&a, compact.Body.Bytes())
+	json.Compact(&b, pretty.Body.Bytes())
+	if a.String() != b.String() {
+		t.Errorf("pretty body %s differs from %s", b.String(), a.String())
+	}
+	for _, h := range []string{"Content-Type", "ETag"} {
+		if compact.Header().Get(h) != pretty.Header().Get(h) {
+			t.Errorf("%s = %q pretty, %q compact", h, pretty.Header().Get(h), compact.Header().Get(h))
+		}
+	}
+}
+
+func TestResponseEnvelope(t *testing.T) {
+	for _, enveloped := range []bool{false, true} {
+		t.Run(fmt.Sprint("enveloped ", enveloped), func(t *testing.T) {
+			s := newTestServer(t)
+			s.createTenant("acme")
+			c := *cfg()
+			c.ResponseEnvelope = enveloped
+			setConfig(&c)
+
+			rec := s.do("GET", "/organizations/acme", nil)
+			wantStatus(t, rec, http.StatusOK)
+			var org Organization
+			if enveloped {
+				org = decode[struct {
+					Data Organization `json:"data"`
+				}](t, rec).Data
+			} else {
+				org = decode[Organization](t, rec)
+			}
+			if org.ID != "acme" {
+				t.Errorf("organization = %s", rec.Body)
+			}
+
+			rec = s.do("GET", "/organizations?limit=1", nil)
+			wantStatus(t, rec, http.StatusOK)
+			if enveloped {
+				list := decode[struct {
+					Data []Organization `json:"data"`
+					Meta Page           `json:"meta"`
+				}](t, rec)
+				if len(list.Data) != 1 || list.Meta.Limit != 1 {
+					t.Errorf("list = %s", rec.Body)
+				}
+			} else if got := decode[[]Organization](t, rec); len(got) != 1 {
+				t.Errorf("list = %s", rec.Body)
+			}
+
+			rec = s.do("GET", "/organizations/missing", nil)
+			wantStatus(t, rec, http.StatusNotFound)
+			if enveloped {
+				if got := decode[errorEnvelope](t, rec).Error; got != "organization not found" {
+					t.Errorf("error = %q", got)
+				}
+				if ct := rec.Header().Get("Content-Type"); ct != jsonContentType {
+					t.Errorf("error Content-Type = %q", ct)
+				}
+			} else if got := strings.TrimSpace(rec.Body.String()); got != "organization not found" {
+				t.Errorf("error = %q", got)
+			}
+		})
+	}
+}
+
+func TestJSONCharset(t *testing.T) {
+	s := newTestServer(t)
+	org := Organization{ID: "acme", Name: "Балапан", Config: s.tenantConfig("acme")}
+	rec := s.do("POST", "/organizations", org)
+	wantStatus(t, rec, http.StatusOK)
+
+	rec = s.do("GET", "/organizations/acme", nil)
+	wantStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Content-Type"); got != jsonContentType {
+		t.Errorf("Content-Type = %q, want %q", got, jsonContentType)
+	}
+	if got := decode[Organization](t, rec).Name; got != "Балапан" {
+		t.Errorf("name = %q, want Балапан", got)
+	}
+
+	// Errors declare it too, enveloped or not.
+	for _, envelope := range []bool{false, true} {
+		c := *cfg()
+		c.ResponseEnvelope = envelope
+		setConfig(&c)
+		rec := s.do("GET", "/organizations/gone", nil)
+		wantStatus(t, rec, http.StatusNotFound)
+		if got := rec.Header().Get("Content-Type"); !strings.HasSuffix(got, "; charset=utf-8") {
+			t.Errorf("envelope %v: error Content-Type = %q", envelope, got)
+		}
+	}
+}
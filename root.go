@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const serviceName = "multy-tenant-go-app"
+
+// serviceVersion is set at build time with
+// -ldflags "-X main.serviceVersion=...".
+var serviceVersion = "dev"
+
+type ServiceInfo struct {
+	Name    string   `json:"name"`
+	Version string   `json:"version"`
+	Routes  []string `json:"routes,omitempty"`
+}
+
+// root describes the service. The registered routes are listed only in
+// debug mode, to keep the API surface out of production responses.
+func root(w http.ResponseWriter, r *http.Request) {
+	info := ServiceInfo{Name: serviceName, Version: serviceVersion}
+	if cfg().Debug {
+		routes := chi.RouteContext(r.Context()).Routes
+		err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			info.Routes = append(info.Routes, method+" "+strings.Replace(route, "/*/", "/", -1))
+			return nil
+		})
+		if err != nil {
+			writeError(w, newError(nil, "could not list routes", err))
+			return
+		}
+		sort.Strings(info.Routes)
+	}
+	writeJSON(w, r, http.StatusOK, info)
+}
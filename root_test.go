@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestRoot(t *testing.T) {
+	for _, debug := range []bool{false, true} {
+		t.Run(fmt.Sprint("debug ", debug), func(t *testing.T) {
+			s := newTestServer(t, func(c *Config) { c.Debug = debug })
+			rec := s.do("GET", "/", nil)
+			wantStatus(t, rec, http.StatusOK)
+			info := decode[ServiceInfo](t, rec)
+			if info.Name != serviceName || info.Version != serviceVersion {
+				t.Errorf("info = %+v", info)
+			}
+			if !debug && info.Routes != nil {
+				t.Errorf("routes listed outside debug mode: %v", info.Routes)
+			}
+			if debug && !slices.Contains(info.Routes, "GET /organizations/{id}") {
+				t.Errorf("routes = %v, want GET /organizations/{id} among them", info.Routes)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTrailingSlashIgnored(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+
+	for _, path := range []string{"/organizations/", "/organizations/acme/", "/v2/organizations/"} {
+		if rec := s.do("GET", path, nil); rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d", path, rec.Code, http.StatusOK)
+		}
+	}
+	// The tenant middleware still runs behind the slash.
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens/", nil), http.StatusOK)
+	wantStatus(t, s.do("GET", "/kindergartens/", nil), http.StatusBadRequest)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// apiScopes are the scopes an API key may be given. A resource's write
+// scope doesn't imply its read scope.
+var apiScopes = []string{
+	"kindergartens:read", "kindergartens:write",
+	"settings:read", "settings:write",
+	"users:read",
+}
+
+// validateScopes checks that every scope is one of apiScopes.
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !slices.Contains(apiScopes, scope) {
+			return newError(ErrUnprocessable, fmt.Sprintf("unknown scope %q, scopes are %s", scope, strings.Join(apiScopes, ", ")), nil)
+		}
+	}
+	return nil
+}
+
+// RequireScope refuses with 403 requests authenticated by an API key that
+// lacks scope. Requests without a key, which only name their tenant, are
+// let through as before unless Config.RequireAPIKey has TenantMiddleware
+// refuse them. It must run after TenantMiddleware, and before
+// CacheTenantReads so that cached responses are guarded too.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := apiKey(r.Context()); key != nil && !slices.Contains(key.Scopes, scope) {
+				writeError(w, newError(ErrForbidden, "API key lacks the "+scope+" scope", nil))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireResourceScope requires resource's read scope for GET and HEAD
+// requests, and its write scope for the others, see RequireScope.
+func RequireResourceScope(resource string) func(http.Handler) http.Handler {
+	read, write := RequireScope(resource+":read"), RequireScope(resource+":write")
+	return func(next http.Handler) http.Handler {
+		readNext, writeNext := read(next), write(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead:
+				readNext.ServeHTTP(w, r)
+			default:
+				writeNext.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// mintKey mints an API key of tenant id with the given scopes.
+func (s *testServer) mintKey(id string, scopes ...string) string {
+	s.t.Helper()
+	rec := s.admin("POST", "/admin/tenants/"+id+"/api-keys", map[string]interface{}{"name": "test", "scopes": scopes})
+	wantStatus(s.t, rec, http.StatusCreated)
+	return decode[map[string]interface{}](s.t, rec)["key"].(string)
+}
+
+func TestRequireScope(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	auth := "ApiKey " + s.mintKey("acme", "kindergartens:read")
+
+	wantStatus(t, s.do("GET", "/kindergartens", nil, "Authorization", auth), http.StatusOK)
+	wantStatus(t, s.do("POST", "/kindergartens", Kindergarten{Name: "Sunflower"}, "Authorization", auth), http.StatusForbidden)
+	wantStatus(t, s.do("GET", "/settings", nil, "Authorization", auth), http.StatusForbidden)
+	wantStatus(t, s.do("GET", "/users/stats", nil, "Authorization", auth), http.StatusForbidden)
+	// A key of another tenant.
+	s.createTenant("beta")
+	wantStatus(t, s.tenant("beta", "GET", "/kindergartens", nil, "Authorization", auth), http.StatusForbidden)
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.RequireAPIKey = true })
+	s.createTenant("acme")
+
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens", nil), http.StatusUnauthorized)
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens", Kindergarten{Name: "Sunflower"}), http.StatusUnauthorized)
+	auth := "ApiKey " + s.mintKey("acme", "kindergartens:read", "kindergartens:write")
+	wantStatus(t, s.do("POST", "/kindergartens", Kindergarten{Name: "Sunflower"}, "Authorization", auth), http.StatusCreated)
+}
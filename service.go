@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrganizationService holds the organization logic, independent of HTTP.
+type OrganizationService struct {
+	db *gorm.DB
+}
+
+func NewOrganizationService(db *gorm.DB) *OrganizationService {
+	return &OrganizationService{db: db}
+}
+
+// Create stores a new organization and provisions its tenant database,
+// returning the organization as stored. If that fails, it returns an
+// ErrUnavailable error and the organization stays in StatusProvisioning,
+// until it is moved to StatusActive by hand or the create is retried:
+// creating an organization identical to one still in StatusProvisioning
+// provisions that one again. Organizations without a DSN are returned in
+// StatusProvisioning, waiting for one.
+func (s *OrganizationService) Create(ctx context.Context, org Organization) (Organization, error) {
+	inserted, err := s.insert(ctx, org, nil)
+	if err != nil {
+		existing, getErr := s.Get(ctx, org.ID)
+		if !errors.Is(err, ErrConflict) || getErr != nil || !existing.retryOf(org) {
+			return inserted, err
+		}
+		inserted = existing
+	}
+	org = inserted
+
+	already, err := s.provision(ctx, org, true)
+	if errors.Is(err, errTenantNotConfigured) {
+		return s.Get(ctx, org.ID)
+	}
+	if err != nil {
+		log.Printf("tenant %s: provisioning failed: %v", org.ID, err)
+		return org, newError(ErrUnavailable, "could not provision the tenant database, retry to provision it again", err)
+	}
+	if already {
+		log.Printf("tenant %s: database was already provisioned", org.ID)
+	}
+	if err := s.activate(ctx, org); err != nil {
+		return org, err
+	}
+	return s.Get(ctx, org.ID)
+}
+
+// CreateAsync stores a new organization in StatusProvisioning along with a
+// ProvisioningJob, and leaves provisioning its tenant database to
+// provisionTenants.
+func (s *OrganizationService) CreateAsync(ctx context.Context, org Organization) (Organization, error) {
+	org, err := s.insert(ctx, org, func(tx *gorm.DB) error {
+		return tx.Create(&ProvisioningJob{OrganizationID: org.ID}).Error
+	})
+	if err != nil {
+		return org, err
+	}
+	wakeProvisioner()
+	return s.Get(ctx, org.ID)
+}
+
+// insert stores a new organization in StatusProvisioning, running also, if
+// set, in the same transaction.
+func (s *OrganizationService) insert(ctx context.Context, org Organization, also func(tx *gorm.DB) error) (Organization, error) {
+	org.Status = StatusProvisioning
+	org.NameKey = organizationNameKey(org.Name)
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&org).Error; err != nil {
+			return err
+		}
+		if also != nil {
+			return also(tx)
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey) && s.exists(ctx, org.ID):
+		return org, newError(ErrConflict, "organization already exists", err)
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return org, errNameTaken(org, err)
+	case err != nil:
+		return org, newError(nil, "could not create organization", err)
+	}
+	resetTenantOrigins()
+	return org, nil
+}
+
+// activate moves a provisioned organization to StatusActive, unless it has
+// left StatusProvisioning some other way meanwhile, and records the
+// organization.created event along with it.
+func (s *OrganizationService) activate(ctx context.Context, org Organization) error {
+	org.Status = StatusActive
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Organization{}).Where("id = ? AND status = ?", org.ID, StatusProvisioning).Update("status", org.Status)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return enqueueOrganizationEvent(tx, EventOrganizationCreated, org)
+	})
+	if err != nil {
+		return newError(nil, "could not activate organization", err)
+	}
+	wakeOutbox()
+	return nil
+}
+
+// provision creates and migrates the organization's tenant database, and
+// opens it for requests. With seed, a database provisioned for the first
+// time gets the seed kindergartens. Running it again on a database it
+// provisioned before brings that one up to date, if needed, and reports it
+// as already provisioned; migrations are recorded and never run twice, and
+// the seed isn't added again, so that records deleted since stay deleted.
+func (s *OrganizationService) provision(ctx context.Context, org Organization, seed bool) (already bool, err error) {
+	tc, err := parseTenantConfig(org.ID, org.Config)
+	if err != nil {
+		return false, err
+	}
+	tc.create = true
+
+	db, err := openTenantDB(ctx, tc.DSN)
+	if err != nil {
+		return false, err
+	}
+	defer closeDB(db)
+	version, err := tenantSchemaVersion(db)
+	if err != nil {
+		return false, err
+	}
+	if err := migrateTenantOnce(tc, db); err != nil {
+		return version != "", err
+	}
+	if seed && version == "" {
+		if err := seedKindergartens(db.WithContext(ctx)); err != nil {
+			return false, err
+		}
+	}
+	_, err = getTenantDB(ctx, tc)
+	return version != "", err
+}
+
+// seedKindergartens adds the kindergartens every new tenant starts with.
+func seedKindergartens(db *gorm.DB) error {
+	seed := []Kindergarten{{ID: "1", Name: "Kindergarten 1"}, {ID: "2", Name: "Kindergarten 2"}}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&seed).Error
+}
+
+func (s *OrganizationService) Get(ctx context.Context, id string) (Organization, error) {
+	var org Organization
+	err := s.db.WithContext(ctx).First(&org, "id = ?", id).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return org, newError(ErrNotFound, "organization not found", err)
+	case err != nil:
+		return org, newError(nil, "could not get organization", err)
+	}
+	return org, nil
+}
+
+// GetMany returns the organizations with the given IDs, keyed by ID.
+// Missing IDs are left out.
+func (s *OrganizationService) GetMany(ctx context.Context, ids []string) (map[string]Organization, error) {
+	var organizations []Organization
+	if err := s.db.WithContext(ctx).Where("id IN ?", ids).Find(&organizations).Error; err != nil {
+		return nil, newError(nil, "could not get organizations", err)
+	}
+	byID := make(map[string]Organization, len(organizations))
+	for _, org := range organizations {
+		byID[org.ID] = org
+	}
+	return byID, nil
+}
+
+func (s *OrganizationService) Count(ctx context.Context, filter OrganizationFilter) (int64, error) {
+	db, err := filter.apply(s.db.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if err := db.Model(&Organization{}).Count(&n).Error; err != nil {
+		return 0, newError(nil, "could not count organizations", err)
+	}
+	return n, nil
+}
+
+// List returns a page of organizations along with the kindergartens of
+// their tenants. A failing tenant database doesn't fail the list; its
+// organization reports the failure in KindergartensError instead.
+// Organizations without a tenant database yet are left as they are.
+func (s *OrganizationService) List(ctx context.Context, page Page, filter OrganizationFilter) ([]Organization, error) {
+	db, err := filter.apply(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var organizations []Organization
+	if err := page.paginate(db).Find(&organizations).Error; err != nil {
+		return nil, newError(nil, "could not list organizations", err)
+	}
+
+	for i, org := range organizations {
+		// Stop querying tenants for a client that went away.
+		if err := ctx.Err(); err != nil {
+			return nil, newError(ErrUnavailable, "request cancelled", err)
+		}
+		kindergartens, err := s.Kindergartens(ctx, org)
+		if errors.Is(err, errTenantNotConfigured) {
+			continue
+		}
+		if err != nil {
+			log.Printf("tenant %s: %v", org.ID, err)
+			organizations[i].KindergartensError = errorMessage(err)
+			continue
+		}
+		organizations[i].Kindergartens = kindergartens
+	}
+	return organizations, nil
+}
+
+// Kindergartens lists the kindergartens in the organization's tenant
+// database.
+func (s *OrganizationService) Kindergartens(ctx context.Context, org Organization) ([]Kindergarten, error) {
+	tc, err := parseTenantConfig(org.ID, org.Config)
+	if errors.Is(err, errTenantNotConfigured) {
+		return nil, newError(ErrUnavailable, "tenant not configured", err)
+	}
+	if err != nil {
+		return nil, newError(nil, "invalid tenant config", err)
+	}
+	tenantDB, err := getTenantReplicaDB(ctx, tc)
+	if err != nil {
+		return nil, tenantDBErr(err)
+	}
+
+	var kindergartens []Kindergarten
+	if err := orderByPrimaryKey(tenantDB.WithContext(ctx)).Find(&kindergartens).Error; err != nil {
+		return nil, newError(nil, "could not list kindergartens", err)
+	}
+	return kindergartens, nil
+}
+
+func (s *OrganizationService) Update(ctx context.Context, org Organization) (Organization, error) {
+	org.NameKey = organizationNameKey(org.Name)
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&org).Error; err != nil {
+			return err
+		}
+		return enqueueOrganizationEvent(tx, EventOrganizationUpdated, org)
+	})
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return org, errNameTaken(org, err)
+	case err != nil:
+		return org, newError(nil, "could not update organization", err)
+	}
+	resetTenantOrigins()
+	// The config may point the tenant at another database.
+	tenantCache.invalidate(org.ID)
+	wakeOutbox()
+	return org, nil
+}
+
+func (s *OrganizationService) exists(ctx context.Context, id string) bool {
+	var n int64
+	s.db.WithContext(ctx).Model(&Organization{}).Where("id = ?", id).Count(&n)
+	return n > 0
+}
+
+// retryOf reports whether creating org again would create o: o is still
+// waiting for its tenant database, and was created with the same name and
+// config.
+func (o Organization) retryOf(org Organization) bool {
+	return o.Status == StatusProvisioning && o.Name == org.Name && o.Config == org.Config
+}
+
+func errNameTaken(org Organization, err error) error {
+	return newError(ErrConflict, fmt.Sprintf("organization name %q is already taken", org.Name), err)
+}
+
+// Rename changes the ID of an organization, along with the records keyed
+// by it. The tenant database stays as it is: a DSN rendered from
+// Config.TenantDSNTemplate with the old ID is written into the config.
+func (s *OrganizationService) Rename(ctx context.Context, id, newID string) (Organization, error) {
+	var org Organization
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&org, "id = ?", id).Error; err != nil {
+			return err
+		}
+		var n int64
+		if err := tx.Model(&Organization{}).Where("id = ?", newID).Count(&n).Error; err != nil {
+			return err
+		}
+		if n > 0 {
+			return gorm.ErrDuplicatedKey
+		}
+
+		if tc, err := parseTenantConfig(id, org.Config); err == nil && tc.templated {
+			if org.Config, err = pinTenantDSN(org.Config, tc); err != nil {
+				return err
+			}
+			if err := tx.Model(&org).Update("config", org.Config).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&Organization{}).Where("id = ?", id).Update("id", newID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&OutboxEvent{}).Where("organization_id = ?", id).Update("organization_id", newID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&RateLimitBucket{}).Where("id = ?", id).Update("id", newID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&ProvisioningJob{}).Where("organization_id = ?", id).Update("organization_id", newID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&APIKey{}).Where("organization_id = ?", id).Update("organization_id", newID).Error; err != nil {
+			return err
+		}
+		org.ID = newID
+		return enqueueOrganizationEvent(tx, EventOrganizationUpdated, org)
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return org, newError(ErrNotFound, "organization not found", err)
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return org, newError(ErrConflict, "organization "+newID+" already exists", err)
+	case err != nil:
+		return org, newError(nil, "could not rename organization", err)
+	}
+
+	tenantRateLimiter.rename(id, newID)
+	tenantCache.invalidate(id)
+	resetStatsCache()
+	wakeOutbox()
+	return org, nil
+}
+
+func (s *OrganizationService) Delete(ctx context.Context, id string) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&Organization{}, "id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&APIKey{}, "organization_id = ?", id).Error
+	})
+	if err != nil {
+		return newError(nil, "could not delete organization", err)
+	}
+	resetTenantOrigins()
+	// An organization created with the same ID must not see these.
+	tenantCache.invalidate(id)
+	return nil
+}
+
+// UserService holds the user logic, independent of HTTP.
+type UserService struct {
+	db *gorm.DB
+}
+
+func NewUserService(db *gorm.DB) *UserService {
+	return &UserService{db: db}
+}
+
+// normalizeUsername is the form usernames are stored and compared in, so
+// that the unique index also rejects names differing only in case.
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// hashUserPassword hashes the password of a user about to be saved, unless
+// it is the stored hash.
+func hashUserPassword(user *User) error {
+	if user.Password == "" || isPasswordHash(user.Password) {
+		return nil
+	}
+	hash, err := hashPassword(user.Password)
+	if err != nil {
+		return newError(nil, "could not hash password", err)
+	}
+	user.Password = hash
+	return nil
+}
+
+// Create stores a new user, hashing the password, and returns the user as
+// stored.
+func (s *UserService) Create(ctx context.Context, user User) (User, error) {
+	user.Username = normalizeUsername(user.Username)
+	if err := hashUserPassword(&user); err != nil {
+		return user, err
+	}
+	err := s.db.WithContext(ctx).Create(&user).Error
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return user, newError(ErrConflict, "user already exists", err)
+	case err != nil:
+		return user, newError(nil, "could not create user", err)
+	}
+	return s.Get(ctx, fmt.Sprint(user.ID))
+}
+
+// UsernameTaken reports whether a user has the username, which Create
+// would then refuse. Usernames are unique regardless of case, see
+// normalizeUsername; rows written before that are matched with LOWER, as
+// Import does.
+func (s *UserService) UsernameTaken(ctx context.Context, username string) (bool, error) {
+	var n int64
+	err := s.db.WithContext(ctx).Model(&User{}).Where("LOWER(username) = ?", normalizeUsername(username)).Count(&n).Error
+	if err != nil {
+		return false, newError(nil, "could not check username", err)
+	}
+	return n > 0, nil
+}
+
+func (s *UserService) Get(ctx context.Context, id string) (User, error) {
+	var user User
+	err := s.db.WithContext(ctx).First(&user, "id = ?", id).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return user, newError(ErrNotFound, "user not found", err)
+	case err != nil:
+		return user, newError(nil, "could not get user", err)
+	}
+	return user, nil
+}
+
+func (s *UserService) Count(ctx context.Context, filter UserFilter) (int64, error) {
+	var n int64
+	if err := filter.apply(s.db.WithContext(ctx)).Model(&User{}).Count(&n).Error; err != nil {
+		return 0, newError(nil, "could not list users", err)
+	}
+	return n, nil
+}
+
+// CountByRole counts the users of each role, in order of role.
+func (s *UserService) CountByRole(ctx context.Context) ([]RoleCount, error) {
+	roles := []RoleCount{}
+	err := s.db.WithContext(ctx).Model(&User{}).
+		Select("role, COUNT(*) AS users").Group("role").Order("role").Scan(&roles).Error
+	if err != nil {
+		return nil, newError(nil, "could not count users", err)
+	}
+	return roles, nil
+}
+
+func (s *UserService) List(ctx context.Context, page Page, filter UserFilter) ([]User, error) {
+	var users []User
+	if err := page.paginate(filter.apply(s.db.WithContext(ctx))).Find(&users).Error; err != nil {
+		return nil, newError(nil, "could not list users", err)
+	}
+	return users, nil
+}
+
+func (s *UserService) Update(ctx context.Context, user User) (User, error) {
+	user.Username = normalizeUsername(user.Username)
+	if err := hashUserPassword(&user); err != nil {
+		return user, err
+	}
+	err := s.db.WithContext(ctx).Save(&user).Error
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return user, newError(ErrConflict, "user already exists", err)
+	case err != nil:
+		return user, newError(nil, "could not update user", err)
+	}
+	return user, nil
+}
+
+// Authenticate checks a user's credentials for the login flow and records
+// the login. Usernames match regardless of case, as UsernameTaken does. A
+// password stored with outdated hash parameters is hashed again with the
+// current ones, which only a successful login makes possible.
+func (s *UserService) Authenticate(ctx context.Context, username, password string) (User, error) {
+	var user User
+	err := s.db.WithContext(ctx).First(&user, "LOWER(username) = ?", normalizeUsername(username)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !checkPassword(user.Password, password)) {
+		return user, newError(ErrUnauthorized, "invalid username or password", err)
+	}
+	if err != nil {
+		return user, newError(nil, "could not authenticate user", err)
+	}
+
+	if passwordOutdated(user.Password) {
+		if hash, err := hashPassword(password); err != nil {
+			log.Printf("user %d: could not rehash password: %v", user.ID, err)
+		} else if err := s.db.WithContext(ctx).Model(&user).Update("password", hash).Error; err != nil {
+			log.Printf("user %d: could not store rehashed password: %v", user.ID, err)
+		}
+	}
+	return user, s.RecordLogin(ctx, user.ID)
+}
+
+// PasswordHashReport counts the users whose password passwordOutdated.
+type PasswordHashReport struct {
+	Users    int64 `json:"users"`
+	Outdated int64 `json:"outdated"`
+}
+
+func (s *UserService) PasswordHashReport(ctx context.Context) (PasswordHashReport, error) {
+	var report PasswordHashReport
+	var users []User
+	err := s.db.WithContext(ctx).Select("id", "password").FindInBatches(&users, 500, func(*gorm.DB, int) error {
+		for _, u := range users {
+			report.Users++
+			if passwordOutdated(u.Password) {
+				report.Outdated++
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return report, newError(nil, "could not list users", err)
+	}
+	return report, nil
+}
+
+// RecordLogin stamps the user's LastLoginAt after a successful login.
+func (s *UserService) RecordLogin(ctx context.Context, id uint) error {
+	err := s.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Update("last_login_at", time.Now().UTC()).Error
+	if err != nil {
+		return newError(nil, "could not record login", err)
+	}
+	return nil
+}
+
+func (s *UserService) Delete(ctx context.Context, id string) error {
+	if err := s.db.WithContext(ctx).Delete(&User{}, "id = ?", id).Error; err != nil {
+		return newError(nil, "could not delete user", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCreateOrganizationProvisioningFails(t *testing.T) {
+	s := newTestServer(t)
+	// SQLite doesn't create missing directories.
+	dir := filepath.Join(s.dir, "missing")
+	config, _ := json.Marshal(map[string]string{"dsn": filepath.Join(dir, "acme.db")})
+	org := Organization{ID: "acme", Name: "Acme", Config: string(config)}
+
+	wantStatus(t, s.do("POST", "/organizations", org), http.StatusServiceUnavailable)
+	if got := decode[Organization](t, s.do("GET", "/organizations/acme", nil)); got.Status != StatusProvisioning {
+		t.Errorf("status = %s, want %s", got.Status, StatusProvisioning)
+	}
+
+	// Retrying once the database can be created provisions it.
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rec := s.do("POST", "/organizations", org)
+	wantStatus(t, rec, http.StatusOK)
+	if got := decode[Organization](t, rec); got.Status != StatusActive {
+		t.Errorf("status = %s, want %s", got.Status, StatusActive)
+	}
+}
+
+func TestCreateOrganizationWithoutDSN(t *testing.T) {
+	s := newTestServer(t)
+	rec := s.do("POST", "/organizations", Organization{ID: "acme", Name: "Acme"})
+	wantStatus(t, rec, http.StatusAccepted)
+	if got := rec.Header().Get("Location"); got != "/organizations/acme/provisioning" {
+		t.Errorf("Location = %q", got)
+	}
+	if got := decode[Organization](t, rec); got.Status != StatusProvisioning {
+		t.Errorf("status = %s, want %s", got.Status, StatusProvisioning)
+	}
+}
+
+// The services are used directly, without the router.
+
+func TestUserService(t *testing.T) {
+	newTestServer(t)
+	users := NewUserService(centralDB)
+	ctx := context.Background()
+
+	user, err := users.Create(ctx, User{Username: "erin", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Create(ctx, User{Username: "erin", Password: "secret"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("creating erin again: err = %v, want %v", err, ErrConflict)
+	}
+
+	user.Role = "admin"
+	if _, err := users.Update(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := users.Get(ctx, fmt.Sprint(user.ID)); err != nil || got.Role != "admin" {
+		t.Errorf("updated user = %+v, %v", got, err)
+	}
+
+	if err := users.Delete(ctx, fmt.Sprint(user.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Get(ctx, fmt.Sprint(user.ID)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted user: err = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestOrganizationService(t *testing.T) {
+	s := newTestServer(t)
+	orgs := NewOrganizationService(centralDB)
+	ctx := context.Background()
+
+	if _, err := orgs.Create(ctx, Organization{ID: "acme", Name: "Acme", Config: s.tenantConfig("acme")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orgs.Create(ctx, Organization{ID: "acme", Name: "Other", Config: s.tenantConfig("acme")}); !errors.Is(err, ErrConflict) {
+		t.Errorf("same ID: err = %v, want %v", err, ErrConflict)
+	}
+	if n, err := orgs.Count(ctx, OrganizationFilter{}); err != nil || n != 1 {
+		t.Errorf("Count = %d, %v, want 1", n, err)
+	}
+
+	if err := orgs.Delete(ctx, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orgs.Get(ctx, "acme"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted organization: err = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestListOrganizationsTenantFailure(t *testing.T) {
+	s := newTestServer(t)
+	for _, id := range []string{"acme", "beta"} {
+		// Provisioning seeds kindergartens.
+		s.createTenant(id)
+	}
+	// Its database can't be opened: SQLite doesn't create directories.
+	gone := Organization{ID: "gone", Name: "Gone", Config: filepath.Join(s.dir, "missing", "gone.db")}
+	if err := centralDB.Create(&gone).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rec := s.do("GET", "/organizations", nil)
+	wantStatus(t, rec, http.StatusOK)
+	organizations := decode[[]Organization](t, rec)
+	if len(organizations) != 3 {
+		t.Fatalf("listed %d organizations, want 3", len(organizations))
+	}
+	for _, org := range organizations {
+		switch {
+		case org.ID == "gone" && (org.KindergartensError == "" || len(org.Kindergartens) > 0):
+			t.Errorf("gone: kindergartens %v, error %q, want only an error", org.Kindergartens, org.KindergartensError)
+		case org.ID != "gone" && (org.KindergartensError != "" || len(org.Kindergartens) == 0):
+			t.Errorf("%s: kindergartens %v, error %q, want only kindergartens", org.ID, org.Kindergartens, org.KindergartensError)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := newTestServer(t)
+	rec := s.do("POST", "/users", map[string]string{"username": "erin", "password": "secret"})
+	wantStatus(t, rec, http.StatusOK)
+	erin := decode[User](t, rec)
+
+	for _, tt := range []struct {
+		username, password string
+		status             int
+	}{
+		{"erin", "secret", http.StatusOK},
+		{"Erin", "secret", http.StatusOK},
+		{"erin", "wrong", http.StatusUnauthorized},
+		{"frank", "secret", http.StatusUnauthorized},
+		{"erin", "", http.StatusUnprocessableEntity},
+	} {
+		rec := s.do("POST", "/users/login", map[string]string{"username": tt.username, "password": tt.password})
+		wantStatus(t, rec, tt.status)
+		if tt.status == http.StatusOK {
+			if got := decode[User](t, rec); got.ID != erin.ID {
+				t.Errorf("%s: logged in as %+v, want erin", tt.username, got)
+			}
+		}
+	}
+}
+
+func TestLastLogin(t *testing.T) {
+	s := newTestServer(t)
+	var ids []uint
+	for _, username := range []string{"recent", "dormant", "never"} {
+		rec := s.do("POST", "/users", map[string]string{"username": username, "password": "secret"})
+		wantStatus(t, rec, http.StatusOK)
+		ids = append(ids, decode[User](t, rec).ID)
+	}
+	before := time.Now().UTC().Add(-time.Second)
+	for _, username := range []string{"recent", "dormant"} {
+		wantStatus(t, s.do("POST", "/users/login", map[string]string{"username": username, "password": "secret"}), http.StatusOK)
+	}
+	long := time.Now().AddDate(-1, 0, 0)
+	if err := centralDB.Model(&User{}).Where("id = ?", ids[1]).Update("last_login_at", long).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	user := decode[User](t, s.do("GET", fmt.Sprint("/users/", ids[0]), nil))
+	if user.LastLoginAt == nil || user.LastLoginAt.Before(before) {
+		t.Errorf("last_login_at = %v, want the login time", user.LastLoginAt)
+	}
+	if user := decode[User](t, s.do("GET", fmt.Sprint("/users/", ids[2]), nil)); user.LastLoginAt != nil {
+		t.Errorf("never logged in, but last_login_at = %v", user.LastLoginAt)
+	}
+
+	usernames := func(path string) []string {
+		rec := s.do("GET", path, nil)
+		wantStatus(t, rec, http.StatusOK)
+		var names []string
+		for _, u := range decode[[]User](t, rec) {
+			names = append(names, u.Username)
+		}
+		return names
+	}
+	since := time.Now().AddDate(0, -1, 0).UTC().Format(time.RFC3339)
+	if got, want := usernames("/users?inactive_since="+since), []string{"dormant", "never"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("inactive users = %v, want %v", got, want)
+	}
+	// Users who never logged in sort first.
+	if got, want := usernames("/users?sort=last_login_at"), []string{"never", "dormant", "recent"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("users by last login = %v, want %v", got, want)
+	}
+	wantStatus(t, s.do("GET", "/users?inactive_since=yesterday", nil), http.StatusBadRequest)
+}
+
+func TestUsernamesIgnoreCase(t *testing.T) {
+	s := newTestServer(t)
+	rec := s.do("POST", "/users", User{Username: "Alice"})
+	wantStatus(t, rec, http.StatusOK)
+	if got := decode[User](t, rec).Username; got != "alice" {
+		t.Errorf("username = %q, want alice", got)
+	}
+	wantStatus(t, s.do("POST", "/users", User{Username: "ALICE"}), http.StatusConflict)
+	wantStatus(t, s.do("POST", "/users", User{Username: " alice "}), http.StatusConflict)
+
+	rec = s.do("POST", "/users", User{Username: "bob"})
+	wantStatus(t, rec, http.StatusOK)
+	bob := decode[User](t, rec)
+	bob.Username = "aLiCe"
+	wantStatus(t, s.do("PUT", fmt.Sprint("/users/", bob.ID), bob), http.StatusConflict)
+}
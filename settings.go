@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Setting is a tenant's preference, any JSON value stored under a key of
+// its choosing.
+type Setting struct {
+	Key       string          `gorm:"column:id;primaryKey" json:"key"`
+	Value     json.RawMessage `gorm:"not null" json:"value"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// settingKey is what setting keys are limited to, so that they are safe in
+// paths and logs.
+var settingKey = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+func listSettings(w http.ResponseWriter, r *http.Request) {
+	tenantDB := r.Context().Value("tenantDB").(*gorm.DB).WithContext(r.Context())
+
+	page, err := parsePage(r)
+	if err == nil {
+		err = parseCursor(r, &page)
+	}
+	if err != nil {
+		writeError(w, newError(ErrBadRequest, err.Error(), nil))
+		return
+	}
+	count := func() (int64, error) {
+		var n int64
+		return n, tenantDB.Model(&Setting{}).Count(&n).Error
+	}
+	if wantsTotal(r) {
+		if page.Total, err = count(); err != nil {
+			writeError(w, newError(nil, "could not list settings", err))
+			return
+		}
+	}
+
+	var settings []Setting
+	if err := page.paginate(tenantDB).Find(&settings).Error; err != nil {
+		writeError(w, newError(nil, "could not list settings", err))
+		return
+	}
+	if err := page.checkTruncated(len(settings), count); err != nil {
+		writeError(w, newError(nil, "could not list settings", err))
+		return
+	}
+	if n := len(settings); n > 0 {
+		page.setNextCursor(n, settings[n-1].Key)
+	}
+	writeList(w, r, settings, page)
+}
+
+func getSetting(w http.ResponseWriter, r *http.Request) {
+	tenantDB := r.Context().Value("tenantDB").(*gorm.DB).WithContext(r.Context())
+
+	var setting Setting
+	if err := firstOr404(tenantDB, &setting, chi.URLParam(r, "key")); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, setting)
+}
+
+// putSetting stores the JSON request body as the value of a setting,
+// creating it unless it exists. Values are bounded by
+// Config.SettingMaxValueBytes and the number of a tenant's settings by
+// Config.SettingMaxKeys.
+func putSetting(w http.ResponseWriter, r *http.Request) {
+	c := cfg()
+
+	key := chi.URLParam(r, "key")
+	if !settingKey.MatchString(key) {
+		writeError(w, newError(ErrBadRequest, "key must be 1 to 128 letters, digits, '.', '_' or '-'", nil))
+		return
+	}
+	if err := checkContentType(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	value, err := io.ReadAll(io.LimitReader(r.Body, int64(c.SettingMaxValueBytes)+1))
+	if err != nil {
+		writeError(w, newError(ErrBadRequest, "invalid input", err))
+		return
+	}
+	if len(value) > c.SettingMaxValueBytes {
+		writeError(w, newError(ErrUnprocessable, fmt.Sprintf("value must be at most %d bytes", c.SettingMaxValueBytes), nil))
+		return
+	}
+	if !json.Valid(value) {
+		writeError(w, newError(ErrBadRequest, "value must be JSON", nil))
+		return
+	}
+	if err := checkJSON(bytes.NewReader(value)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	setting := Setting{Key: key, Value: value}
+	created := false
+	err = WithTenantTx(r.Context(), func(tx *gorm.DB) error {
+		var exists int64
+		if err := tx.Model(&Setting{}).Where("id = ?", key).Count(&exists).Error; err != nil {
+			return err
+		}
+		if exists == 0 {
+			var n int64
+			if err := tx.Model(&Setting{}).Count(&n).Error; err != nil {
+				return err
+			}
+			if c.SettingMaxKeys > 0 && n >= int64(c.SettingMaxKeys) {
+				return newError(ErrUnprocessable, fmt.Sprintf("a tenant can have at most %d settings", c.SettingMaxKeys), nil)
+			}
+			created = true
+		}
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&setting).Error; err != nil {
+			return err
+		}
+		return tx.First(&setting, "id = ?", key).Error
+	})
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		writeError(w, err)
+		return
+	case err != nil:
+		writeError(w, newError(nil, "could not save setting", err))
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, r, status, setting)
+}
+
+func deleteSetting(w http.ResponseWriter, r *http.Request) {
+	tenantDB := r.Context().Value("tenantDB").(*gorm.DB).WithContext(r.Context())
+
+	result := tenantDB.Delete(&Setting{}, "id = ?", chi.URLParam(r, "key"))
+	switch {
+	case result.Error != nil:
+		writeError(w, newError(nil, "could not delete setting", result.Error))
+		return
+	case result.RowsAffected == 0:
+		writeError(w, newError(ErrNotFound, "setting not found", nil))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSettings(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		c.SettingMaxKeys = 2
+		c.SettingMaxValueBytes = 32
+	})
+	s.createTenant("acme")
+	s.createTenant("beta")
+
+	wantStatus(t, s.tenant("acme", "PUT", "/settings/theme", `"dark"`), http.StatusCreated)
+	wantStatus(t, s.tenant("acme", "PUT", "/settings/theme", `{"mode": "light"}`), http.StatusOK)
+	rec := s.tenant("acme", "GET", "/settings/theme", nil)
+	wantStatus(t, rec, http.StatusOK)
+	var value map[string]string
+	if err := json.Unmarshal(decode[Setting](t, rec).Value, &value); err != nil || value["mode"] != "light" {
+		t.Errorf("value = %s, want the overwritten one", rec.Body.String())
+	}
+	// Settings are per tenant.
+	wantStatus(t, s.tenant("beta", "GET", "/settings/theme", nil), http.StatusNotFound)
+
+	for _, tt := range []struct {
+		key, value string
+		status     int
+	}{
+		{"a:b", `1`, http.StatusBadRequest},
+		{"lang", `not json`, http.StatusBadRequest},
+		{"lang", `"` + strings.Repeat("x", 32) + `"`, http.StatusUnprocessableEntity},
+	} {
+		if rec := s.tenant("acme", "PUT", "/settings/"+tt.key, tt.value); rec.Code != tt.status {
+			t.Errorf("PUT %s %s: status = %d, want %d", tt.key, tt.value, rec.Code, tt.status)
+		}
+	}
+
+	wantStatus(t, s.tenant("acme", "PUT", "/settings/lang", `"kk"`), http.StatusCreated)
+	wantStatus(t, s.tenant("acme", "PUT", "/settings/units", `"metric"`), http.StatusUnprocessableEntity)
+	// Overwriting doesn't count against the limit.
+	wantStatus(t, s.tenant("acme", "PUT", "/settings/lang", `"ru"`), http.StatusOK)
+
+	list := decode[[]Setting](t, s.tenant("acme", "GET", "/settings", nil))
+	if len(list) != 2 || list[0].Key != "lang" || list[1].Key != "theme" {
+		t.Errorf("settings = %+v, want lang and theme", list)
+	}
+
+	wantStatus(t, s.tenant("acme", "DELETE", "/settings/theme", nil), http.StatusNoContent)
+	wantStatus(t, s.tenant("acme", "DELETE", "/settings/theme", nil), http.StatusNotFound)
+	wantStatus(t, s.tenant("acme", "PUT", "/settings/units", `"metric"`), http.StatusCreated)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// inFlight counts the requests being served, so that shutdown can wait for
+// them and report those it had to cut off.
+var inFlight atomic.Int64
+
+// drainPollInterval is how often shutdown checks whether inFlight reached
+// zero.
+const drainPollInterval = 10 * time.Millisecond
+
+// TrackInFlight counts the request in inFlight while it is served.
+func TrackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// backgroundLoops runs the loops that work outside of requests, such as the
+// outbox dispatcher, so that shutdown can stop them and wait for the work
+// under way before the databases are closed.
+type backgroundLoops struct {
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// startBackgroundLoops runs each loop in its own goroutine. The loops return
+// once their context is done.
+func startBackgroundLoops(loops ...func(ctx context.Context)) *backgroundLoops {
+	b := &backgroundLoops{}
+	ctx, stop := context.WithCancel(context.Background())
+	b.stop = stop
+	for _, loop := range loops {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			loop(ctx)
+		}()
+	}
+	return b
+}
+
+// wait stops the loops and waits for them to return until ctx is done.
+func (b *backgroundLoops) wait(ctx context.Context) error {
+	b.stop()
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sleep waits for d, and reports false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// serve runs srv and loops until SIGINT or SIGTERM, then stops accepting
+// connections and starting background work, and gives in-flight requests
+// and the work under way Config.ShutdownGracePeriod to finish before
+// closing the databases.
+func serve(srv *http.Server, loops *backgroundLoops) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		panic(fmt.Sprintf("cannot start server: %s", err))
+	case sig := <-stop:
+		log.Printf("received %s, shutting down", sig)
+	}
+
+	shutdown(srv, loops)
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("server stopped: %v", err)
+	}
+
+	closeTenantDBs()
+	closeDB(centralDB)
+	log.Println("server stopped")
+}
+
+// shutdown stops srv accepting connections and loops starting work, and
+// waits up to Config.ShutdownGracePeriod for the requests in flight and the
+// work under way, cutting off the requests still running then.
+func shutdown(srv *http.Server, loops *backgroundLoops) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg().ShutdownGracePeriod)
+	defer cancel()
+	// The loops stop while the requests drain.
+	loopsDone := make(chan error, 1)
+	go func() { loopsDone <- loops.wait(ctx) }()
+	err := srv.Shutdown(ctx)
+	// Shutdown waits for the connections; polling inFlight then waits for
+	// work that outlives them, such as hijacked streams. Polling rather
+	// than waiting on a WaitGroup leaves no waiter behind when the grace
+	// period expires.
+	if err == nil {
+		err = waitDrained(ctx)
+	}
+	if err != nil {
+		log.Printf("shutdown grace period expired with %d requests in flight", inFlight.Load())
+		srv.Close()
+	}
+	if lerr := <-loopsDone; lerr != nil {
+		log.Print("shutdown grace period expired with background work still running")
+		err = errors.Join(err, lerr)
+	}
+	return err
+}
+
+// waitDrained waits until no request is in flight or ctx is done.
+func waitDrained(ctx context.Context) error {
+	tick := time.NewTicker(drainPollInterval)
+	defer tick.Stop()
+	for inFlight.Load() > 0 {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdownGracePeriod(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		duration time.Duration
+		finishes bool
+	}{
+		{"within the grace period", 100 * time.Millisecond, true},
+		{"too slow", time.Minute, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestServer(t, func(c *Config) { c.ShutdownGracePeriod = 500 * time.Millisecond })
+			started := make(chan struct{})
+			srv := &http.Server{Handler: TrackInFlight(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-time.After(tt.duration):
+				case <-r.Context().Done():
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))}
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.Serve(l)
+
+			resc := make(chan error, 1)
+			go func() {
+				resp, err := http.Get("http://" + l.Addr().String())
+				if err == nil {
+					resp.Body.Close()
+				}
+				resc <- err
+			}()
+			<-started
+
+			start := time.Now()
+			err = shutdown(srv, startBackgroundLoops())
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("shutdown took %v", elapsed)
+			}
+			if (err == nil) != tt.finishes {
+				t.Errorf("shutdown: %v", err)
+			}
+			if err := <-resc; (err == nil) != tt.finishes {
+				t.Errorf("request: %v", err)
+			}
+		})
+	}
+}
+
+func TestShutdownWaitsForBackgroundLoops(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		duration time.Duration
+		finishes bool
+	}{
+		{"within the grace period", 100 * time.Millisecond, true},
+		{"too slow", time.Minute, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestServer(t, func(c *Config) { c.ShutdownGracePeriod = 500 * time.Millisecond })
+			started := make(chan struct{})
+			release := make(chan struct{})
+			finished := make(chan struct{})
+			loops := startBackgroundLoops(func(ctx context.Context) {
+				defer close(finished)
+				close(started)
+				// Work under way isn't cancelled.
+				select {
+				case <-time.After(tt.duration):
+				case <-release:
+				}
+			})
+			t.Cleanup(func() {
+				close(release)
+				<-finished
+			})
+			<-started
+
+			srv := &http.Server{Handler: http.NotFoundHandler()}
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.Serve(l)
+
+			start := time.Now()
+			err = shutdown(srv, loops)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("shutdown took %v", elapsed)
+			}
+			if (err == nil) != tt.finishes {
+				t.Errorf("shutdown: %v", err)
+			}
+			select {
+			case <-finished:
+				if !tt.finishes {
+					t.Error("loop finished within the grace period")
+				}
+			default:
+				if tt.finishes {
+					t.Error("shutdown returned before the loop finished")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// streamFlushRows is how many rows of a streamed list are written between
+// flushes.
+const streamFlushRows = 100
+
+// streamErrorTrailer is the trailer a streamed list reports its failure
+// in, once the 200 status has gone out.
+const streamErrorTrailer = "X-Stream-Error"
+
+// wantsStream reports whether a list is to be streamed, see streamList.
+// Clients ask for it with ?stream=true.
+func wantsStream(r *http.Request) bool {
+	stream, _ := strconv.ParseBool(r.URL.Query().Get("stream"))
+	return stream
+}
+
+// streamList writes the T rows of query restricted to p, in the shape
+// writeList gives them, encoding each row as it is scanned instead of
+// loading them all first, so that memory doesn't grow with the list. key
+// returns the primary key of a row, for the next cursor.
+//
+// The status is sent before the first row is read, so a failure after that
+// can't change it: the body is cut short, leaving invalid JSON, and the
+// error is reported in the X-Stream-Error trailer. For the same reason,
+// streamed lists have no Link or X-Next-Cursor header; the envelope still
+// carries the links and the cursor, after the rows.
+func streamList[T any](w http.ResponseWriter, r *http.Request, query *gorm.DB, p Page, key func(T) string) {
+	stmt := &gorm.Statement{DB: query, Context: r.Context()}
+	if err := stmt.Parse(new(T)); err != nil {
+		writeError(w, newError(nil, "could not list rows", err))
+		return
+	}
+	name := stmt.Schema.Table
+	rows, err := p.paginate(query).Model(new(T)).Rows()
+	if err != nil {
+		writeError(w, newError(nil, "could not list "+name, err))
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Trailer", streamErrorTrailer)
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	bw := bufio.NewWriter(w)
+	fail := func(err error) {
+		log.Printf("%s %s: streaming %s failed: %v", r.Method, r.URL.Path, name, err)
+		bw.Flush()
+		w.Header().Set(streamErrorTrailer, "could not list "+name)
+	}
+
+	enveloped := apiVersion(r.Context()) >= apiV2 || cfg().ResponseEnvelope
+	if enveloped {
+		bw.WriteString(`{"data":[`)
+	} else {
+		bw.WriteString("[")
+	}
+	n, last := 0, ""
+	for rows.Next() {
+		var row T
+		if err := query.ScanRows(rows, &row); err != nil {
+			fail(err)
+			return
+		}
+		utcTimes(stmt, reflect.ValueOf(&row).Elem())
+		b, err := json.Marshal(row)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if n > 0 {
+			bw.WriteByte(',')
+		}
+		if _, err := bw.Write(b); err != nil {
+			fail(err)
+			return
+		}
+		n++
+		last = key(row)
+		if n%streamFlushRows == 0 {
+			if err := bw.Flush(); err != nil {
+				fail(err)
+				return
+			}
+			rc.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		fail(err)
+		return
+	}
+
+	bw.WriteString("]")
+	if enveloped {
+		if n > 0 {
+			p.setNextCursor(n, last)
+		}
+		p.setLinks(r, n, wantsTotal(r))
+		meta, err := json.Marshal(p)
+		if err != nil {
+			fail(err)
+			return
+		}
+		bw.WriteString(`,"meta":`)
+		bw.Write(meta)
+		bw.WriteString("}")
+	}
+	bw.WriteString("\n")
+	if err := bw.Flush(); err != nil {
+		log.Printf("%s %s: could not write response: %v", r.Method, r.URL.Path, err)
+	}
+}
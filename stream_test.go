@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestStreamKindergartens(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	// More than a flush of rows, and than the unpaginated limit.
+	var seed []Kindergarten
+	for i := 0; i < 250; i++ {
+		seed = append(seed, Kindergarten{ID: fmt.Sprintf("k%03d", i), Name: fmt.Sprint("Kindergarten ", i)})
+	}
+	if err := s.tenantDB("acme").CreateInBatches(seed, 100).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rec := s.tenant("acme", "GET", "/kindergartens?stream=true", nil)
+	wantStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Trailer"); got != streamErrorTrailer {
+		t.Errorf("Trailer = %q, want %s", got, streamErrorTrailer)
+	}
+	var ids []string
+	for _, k := range decode[[]Kindergarten](t, rec) {
+		ids = append(ids, k.ID)
+	}
+	// Provisioning seeds two kindergartens of its own.
+	if len(ids) != 252 || !slices.IsSorted(ids) {
+		t.Errorf("streamed %d kindergartens, want all 252 in order", len(ids))
+	}
+	if rec.Header().Get(streamErrorTrailer) != "" {
+		t.Errorf("%s = %q", streamErrorTrailer, rec.Header().Get(streamErrorTrailer))
+	}
+
+	rec = s.tenant("acme", "GET", "/v2/kindergartens?stream=true&limit=10", nil)
+	wantStatus(t, rec, http.StatusOK)
+	list := decode[struct {
+		Data []Kindergarten `json:"data"`
+		Meta Page           `json:"meta"`
+	}](t, rec)
+	if len(list.Data) != 10 || list.Meta.NextCursor == "" || list.Meta.Links == nil || list.Meta.Links.First == "" {
+		t.Errorf("v2 stream has %d rows and meta %+v, want 10 and a cursor and links", len(list.Data), list.Meta)
+	}
+}
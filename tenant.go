@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+// tenantModels are the models migrated into every tenant database.
+var tenantModels = []interface{}{&User{}, &Kindergarten{}, &Classroom{}, &Setting{}}
+
+// tenantMigration guards the schema migration of a single tenant database.
+// Unlike sync.Once, a failed migration is retried by the next caller.
+type tenantMigration struct {
+	mu   sync.Mutex
+	done bool
+}
+
+var (
+	tenantMigrationsMu sync.Mutex
+	tenantMigrations   = map[string]*tenantMigration{}
+)
+
+// errReadOnlyTenantDB is returned for schema changes on a replica.
+var errReadOnlyTenantDB = errors.New("tenant database is read-only")
+
+// errTenantDBMissing is returned for tenant databases that don't exist
+// when they are only to be connected to.
+var errTenantDBMissing = errors.New("tenant database does not exist")
+
+// errTenantNotMigrated is returned for tenant databases missing some of the
+// tables of tenantModels.
+var errTenantNotMigrated = errors.New("tenant not migrated")
+
+// verifiedTenants holds the DSNs whose schema passed verifyTenantSchema.
+var verifiedTenants sync.Map
+
+// tenantDBs caches open tenant databases by DSN.
+var tenantDBs = struct {
+	sync.Mutex
+	m map[string]*gorm.DB
+}{m: map[string]*gorm.DB{}}
+
+// tenantOpens coalesces concurrent opens of the same tenant database.
+// Failed opens aren't cached, so the next request tries again.
+var tenantOpens singleflight.Group
+
+// tenantOpenSlots bounds the number of tenant databases being opened at
+// once, so a burst of requests to cold tenants does not open them all in
+// parallel. It is nil when opens are unbounded.
+var tenantOpenSlots chan struct{}
+
+func initTenantDBs() {
+	if cfg().TenantMaxConcurrentOpens > 0 {
+		tenantOpenSlots = make(chan struct{}, cfg().TenantMaxConcurrentOpens)
+	}
+}
+
+// getTenantDB returns the tenant database identified by dsn, opening it
+// unless it is cached. Opening gives up once ctx is done or
+// Config.TenantOpenTimeout has passed, whichever is first; that includes the
+// wait for an open slot. Tenants that keep failing are refused by their
+// circuit breaker without trying.
+//
+// Concurrent requests for a tenant that isn't cached share a single open.
+// It runs detached from the request that started it, so that the others
+// don't fail when that one is cancelled.
+func getTenantDB(ctx context.Context, tc TenantConfig) (*gorm.DB, error) {
+	dsn := tc.DSN
+	if db := cachedTenantDB(dsn); db != nil {
+		return db, nil
+	}
+	if err := allowTenantOpen(dsn, time.Now()); err != nil {
+		return nil, err
+	}
+
+	opened := tenantOpens.DoChan(dsn, func() (interface{}, error) {
+		db, err := openAndCacheTenantDB(context.WithoutCancel(ctx), tc)
+		recordTenantOpen(dsn, err, time.Now())
+		return db, err
+	})
+	select {
+	case res := <-opened:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*gorm.DB), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func openAndCacheTenantDB(ctx context.Context, tc TenantConfig) (*gorm.DB, error) {
+	dsn := tc.DSN
+	ctx, cancel := context.WithTimeout(ctx, cfg().TenantOpenTimeout)
+	defer cancel()
+
+	if tenantOpenSlots != nil {
+		select {
+		case tenantOpenSlots <- struct{}{}:
+			defer func() { <-tenantOpenSlots }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// Another request may have opened it while we waited.
+		if db := cachedTenantDB(dsn); db != nil {
+			return db, nil
+		}
+	}
+
+	if !tc.create {
+		if err := checkTenantDBExists(dsn); err != nil {
+			return nil, err
+		}
+	}
+	db, err := openTenantDB(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if tc.readOnly {
+		if err := refuseDDL(db); err != nil {
+			closeDB(db)
+			return nil, err
+		}
+	}
+	// Checked before migrating, which must not touch such a schema either.
+	if err := checkTenantSchemaNewer(dsn, db); err != nil {
+		closeDB(db)
+		return nil, err
+	}
+	// Replicas get their schema from the primary.
+	if cfg().AutoMigrate && !tc.readOnly {
+		if err := migrateTenantOnce(tc, db); err != nil {
+			closeDB(db)
+			return nil, err
+		}
+	}
+	if err := verifyTenantSchema(dsn, db); err != nil {
+		closeDB(db)
+		return nil, err
+	}
+
+	tenantDBs.Lock()
+	defer tenantDBs.Unlock()
+	if cached, ok := tenantDBs.m[dsn]; ok {
+		closeDB(db)
+		return cached, nil
+	}
+	tenantDBs.m[dsn] = db
+	return db, nil
+}
+
+// getTenantReplicaDB returns the database of the tenant's replica, or of
+// the tenant itself when it has none. See getTenantDB.
+func getTenantReplicaDB(ctx context.Context, tc TenantConfig) (*gorm.DB, error) {
+	return getTenantDB(ctx, tc.replica())
+}
+
+// refuseDDL makes db fail statements that would change its schema, which a
+// replica would reject anyway, less clearly.
+func refuseDDL(db *gorm.DB) error {
+	return db.Callback().Raw().Before("gorm:raw").Register("mtgo:refuse_ddl", func(tx *gorm.DB) {
+		verb, _, _ := strings.Cut(strings.TrimSpace(tx.Statement.SQL.String()), " ")
+		switch strings.ToUpper(verb) {
+		case "CREATE", "ALTER", "DROP", "TRUNCATE", "RENAME":
+			tx.AddError(errReadOnlyTenantDB)
+		}
+	})
+}
+
+func cachedTenantDB(dsn string) *gorm.DB {
+	tenantDBs.Lock()
+	defer tenantDBs.Unlock()
+	return tenantDBs.m[dsn]
+}
+
+// checkTenantDBExists fails with errTenantDBMissing if dsn is a SQLite
+// file that doesn't exist, which opening would create empty, hiding a typo
+// in the DSN. Other drivers never create databases.
+func checkTenantDBExists(dsn string) error {
+	path, ok := sqlitePath(dsn)
+	if !ok {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", errTenantDBMissing, path)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// openTenantDB opens a connection to dsn, giving up once ctx is done.
+func openTenantDB(ctx context.Context, dsn string) (*gorm.DB, error) {
+	type result struct {
+		db  *gorm.DB
+		err error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		db, err := gorm.Open(openDialector(dsn), &gorm.Config{TranslateError: true, Logger: queryLog, NowFunc: utcNow})
+		if err == nil {
+			if err = registerCallbacks(db, registerTenantMetrics, registerQueryTag(dsn)); err != nil {
+				closeDB(db)
+			}
+		}
+		opened <- result{db, err}
+	}()
+
+	select {
+	case res := <-opened:
+		return res.db, res.err
+	case <-ctx.Done():
+		// Nobody is waiting for the connection anymore; close it if the
+		// open ever completes.
+		go func() {
+			if res := <-opened; res.err == nil {
+				closeDB(res.db)
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// migrateTenantOnce migrates the tenant database identified by dsn at most
+// once per process, even when several requests reach a new tenant at once.
+func migrateTenantOnce(tc TenantConfig, db *gorm.DB) error {
+	return applyTenantMigrations(tc, db, false)
+}
+
+// applyTenantMigrations runs the pending migrations of the tenant database,
+// unless this process already did and force isn't set.
+func applyTenantMigrations(tc TenantConfig, db *gorm.DB, force bool) error {
+	if tc.readOnly {
+		return errReadOnlyTenantDB
+	}
+	m := tenantMigrationFor(tc.DSN)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done && !force {
+		return nil
+	}
+	if force {
+		verifiedTenants.Delete(tc.DSN)
+	}
+	_, err := runTenantMigrations(db, tc.MigrationHooks)
+	recordMigrationResult(tc.DSN, err)
+	if err != nil {
+		return err
+	}
+	m.done = true
+	return nil
+}
+
+func tenantMigrationFor(dsn string) *tenantMigration {
+	tenantMigrationsMu.Lock()
+	defer tenantMigrationsMu.Unlock()
+	m, ok := tenantMigrations[dsn]
+	if !ok {
+		m = &tenantMigration{}
+		tenantMigrations[dsn] = m
+	}
+	return m
+}
+
+// verifyTenantSchema checks that the tenant database has every table of
+// tenantModels, so provisioning problems surface before any handler runs.
+// Only successful checks are remembered.
+func verifyTenantSchema(dsn string, db *gorm.DB) error {
+	if _, ok := verifiedTenants.Load(dsn); ok {
+		return nil
+	}
+	for _, model := range tenantModels {
+		if !db.Migrator().HasTable(model) {
+			return errTenantNotMigrated
+		}
+	}
+	verifiedTenants.Store(dsn, struct{}{})
+	return nil
+}
+
+// closeTenantDBs closes and forgets every cached tenant database.
+func closeTenantDBs() {
+	tenantDBs.Lock()
+	defer tenantDBs.Unlock()
+	for dsn, db := range tenantDBs.m {
+		closeDB(db)
+		delete(tenantDBs.m, dsn)
+	}
+}
+
+func closeDB(db *gorm.DB) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return
+	}
+	if err := sqlDB.Close(); err != nil {
+		log.Printf("failed to close database: %v", err)
+	}
+}
+
+// WithTenantTx runs fn in a transaction on the database of the tenant
+// resolved by TenantMiddleware, bound to ctx. The transaction commits if
+// fn returns nil and rolls back otherwise, so handlers changing several
+// tenant tables either change them all or none.
+func WithTenantTx(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	db, ok := ctx.Value("tenantDB").(*gorm.DB)
+	if !ok {
+		return errors.New("no tenant database in context")
+	}
+	return db.WithContext(ctx).Transaction(fn)
+}
+
+// firstOr404 loads the row of dest's model with the given primary key from
+// a tenant database. A missing row is ErrNotFound, named after the model,
+// so that it reads differently from the unknown tenant TenantMiddleware
+// reports.
+func firstOr404(db *gorm.DB, dest interface{}, id string) error {
+	name := strings.ToLower(reflect.TypeOf(dest).Elem().Name())
+	err := db.First(dest, primaryKeyColumn+" = ?", id).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return newError(ErrNotFound, name+" not found", err)
+	case err != nil:
+		return newError(nil, "could not get "+name, err)
+	}
+	return nil
+}
+
+// tenantDBErr describes a getTenantDB failure to clients.
+func tenantDBErr(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return newError(ErrTimeout, "timed out connecting to tenant database", err)
+	case errors.Is(err, errCircuitOpen):
+		return newError(ErrUnavailable, "tenant database unavailable", err)
+	case errors.Is(err, errTenantDBMissing):
+		return newError(ErrUnavailable, "tenant database does not exist", err)
+	case errors.Is(err, errTenantNotMigrated):
+		return newError(ErrUnavailable, "tenant not migrated", err)
+	case errors.Is(err, errTenantSchemaNewer):
+		return newError(ErrUnavailable, "tenant database schema is newer than the service", err)
+	case errors.Is(err, context.Canceled):
+		return newError(ErrUnavailable, "request cancelled", err)
+	default:
+		return newError(nil, "failed to connect to tenant database", err)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	// Time zones load the same without tzdata on the host.
+	_ "time/tzdata"
+)
+
+// TenantConfig is the parsed form of Organization.Config. Configs that are
+// not a JSON object are bare DSNs, the format organizations started with.
+type TenantConfig struct {
+	// DSN locates the tenant database. Configs leaving it out get it from
+	// Config.TenantDSNTemplate, which sets templated.
+	DSN       string `json:"dsn"`
+	templated bool
+
+	// ReplicaDSN locates a read-only replica of the tenant database, which
+	// serves reads that can do with slightly stale data. readOnly marks
+	// the config of the replica itself, see replica.
+	ReplicaDSN string `json:"replica_dsn,omitempty"`
+	readOnly   bool
+
+	// create lets getTenantDB create a database that doesn't exist yet,
+	// which only provisioning should; see checkTenantDBExists.
+	create bool
+
+	// AllowedOrigins overrides Config.CORSAllowedOrigins for the tenant's
+	// routes.
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+
+	// WebhookURL receives the tenant's events, signed with WebhookSecret.
+	WebhookURL    string `json:"webhook_url,omitempty"`
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+
+	// MigrationHooks names the tenantMigrationHooks to run after the
+	// standard migrations.
+	MigrationHooks []string `json:"migration_hooks,omitempty"`
+
+	// MaxInFlight overrides Config.TenantMaxInFlight for the tenant.
+	MaxInFlight int `json:"max_in_flight,omitempty"`
+
+	// Timezone is the IANA name of the tenant's time zone, such as
+	// "Asia/Almaty", for local times and day boundaries; UTC when empty.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+const (
+	tenantIDKey       contextKey = "tenantID"
+	tenantConfigKey   contextKey = "tenantConfig"
+	tenantLocationKey contextKey = "tenantLocation"
+)
+
+// errTenantNotConfigured is returned for organizations without a DSN and
+// no Config.TenantDSNTemplate, which would otherwise open a SQLite
+// database named "".
+var errTenantNotConfigured = errors.New("tenant not configured")
+
+// parseTenantConfig parses the config of the organization with the given
+// ID, rendering Config.TenantDSNTemplate for it if the config has no DSN.
+func parseTenantConfig(id, raw string) (TenantConfig, error) {
+	var tc TenantConfig
+	raw = strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(raw, "{"):
+		if err := json.Unmarshal([]byte(raw), &tc); err != nil {
+			return TenantConfig{}, err
+		}
+	case raw != "":
+		return TenantConfig{DSN: raw}, nil
+	}
+	if strings.TrimSpace(tc.DSN) != "" {
+		return tc, nil
+	}
+
+	tmpl := cfg().TenantDSNTemplate
+	if tmpl == "" {
+		return TenantConfig{}, errTenantNotConfigured
+	}
+	dsn, err := renderTenantDSN(tmpl, id)
+	if err != nil {
+		return TenantConfig{}, err
+	}
+	tc.DSN, tc.templated = dsn, true
+	return tc, nil
+}
+
+// redactedSecret stands in for the secrets shown to clients.
+const redactedSecret = "xxxxx"
+
+// redactTenantConfig returns a raw tenant config with its webhook secret
+// redacted, for showing it to clients. Configs without a secret are
+// returned as they are.
+func redactTenantConfig(raw string) string {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return raw
+	}
+	var secret string
+	if err := json.Unmarshal(fields["webhook_secret"], &secret); err != nil || secret == "" {
+		return raw
+	}
+	fields["webhook_secret"], _ = json.Marshal(redactedSecret)
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return raw
+	}
+	return string(redacted)
+}
+
+// keepWebhookSecret returns an updated raw config with the webhook secret
+// of the stored one if it only has the redacted secret, as configs that
+// clients send back the way they were shown do.
+func keepWebhookSecret(stored, updated string) string {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(updated), &fields); err != nil {
+		return updated
+	}
+	var secret string
+	if err := json.Unmarshal(fields["webhook_secret"], &secret); err != nil || secret != redactedSecret {
+		return updated
+	}
+	storedFields := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(stored), &storedFields); err != nil || storedFields["webhook_secret"] == nil {
+		return updated
+	}
+	fields["webhook_secret"] = storedFields["webhook_secret"]
+	kept, err := json.Marshal(fields)
+	if err != nil {
+		return updated
+	}
+	return string(kept)
+}
+
+// replica returns the config of the tenant's read-only replica, or tc
+// itself when it has none.
+func (tc TenantConfig) replica() TenantConfig {
+	if tc.ReplicaDSN == "" || tc.ReplicaDSN == tc.DSN {
+		return tc
+	}
+	return TenantConfig{DSN: tc.ReplicaDSN, readOnly: true}
+}
+
+// tenantIDFormat is what tenant IDs are limited to, so that they are safe
+// in headers, paths and logs, and rendered into a DSN can't add parameters
+// or name another database.
+var tenantIDFormat = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// tenantIDFormatMessage describes tenantIDFormat to clients.
+const tenantIDFormatMessage = "1 to 64 letters, digits, '_' or '-'"
+
+// renderTenantDSN renders a DSN template such as
+// "postgres://app:secret@db/app?search_path={{.TenantID}}" for a tenant.
+func renderTenantDSN(tmpl, id string) (string, error) {
+	if !tenantIDFormat.MatchString(id) {
+		return "", fmt.Errorf("tenant ID %q can't be used in a DSN template", id)
+	}
+	t, err := template.New("dsn").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid DSN template: %w", err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, map[string]string{"TenantID": id}); err != nil {
+		return "", fmt.Errorf("invalid DSN template: %w", err)
+	}
+
+	dsn := b.String()
+	if strings.TrimSpace(dsn) == "" {
+		return "", errors.New("DSN template renders an empty DSN")
+	}
+	// The error would quote the DSN, password included.
+	if strings.Contains(dsn, "://") {
+		if _, err := url.Parse(dsn); err != nil {
+			return "", errors.New("DSN template renders an invalid URL")
+		}
+	}
+	return dsn, nil
+}
+
+// pinTenantDSN returns raw with the DSN tc was rendered with written into
+// it, so that the organization keeps its database when its ID changes.
+func pinTenantDSN(raw string, tc TenantConfig) (string, error) {
+	fields := map[string]json.RawMessage{}
+	if raw = strings.TrimSpace(raw); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+			return "", err
+		}
+	}
+	dsn, err := json.Marshal(tc.DSN)
+	if err != nil {
+		return "", err
+	}
+	fields["dsn"] = dsn
+	pinned, err := json.Marshal(fields)
+	return string(pinned), err
+}
+
+// EffectiveTenantConfig is a tenant's configuration as the service applies
+// it, with secrets redacted and the global settings the tenant falls back
+// to filled in.
+type EffectiveTenantConfig struct {
+	OrganizationID string             `json:"organization_id"`
+	Status         OrganizationStatus `json:"status"`
+	DSN            string             `json:"dsn"`
+	// DSNTemplated is set when the DSN comes from Config.TenantDSNTemplate.
+	DSNTemplated bool   `json:"dsn_templated"`
+	Driver       string `json:"driver"`
+	ReplicaDSN   string `json:"replica_dsn,omitempty"`
+
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedOriginsDefault is set when the tenant doesn't configure its
+	// origins and Config.CORSAllowedOrigins applies.
+	AllowedOriginsDefault bool `json:"allowed_origins_default"`
+
+	WebhookURL     string   `json:"webhook_url,omitempty"`
+	WebhookSecret  string   `json:"webhook_secret,omitempty"`
+	MigrationHooks []string `json:"migration_hooks"`
+	Timezone       string   `json:"timezone"`
+
+	RateLimitRPS   float64 `json:"rate_limit_rps"`
+	RateLimitBurst int     `json:"rate_limit_burst"`
+	// MaxInFlight is the tenant's limit on concurrent requests, zero when
+	// there is none.
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+func effectiveTenantConfig(org Organization, tc TenantConfig) EffectiveTenantConfig {
+	c := cfg()
+	e := EffectiveTenantConfig{
+		OrganizationID: org.ID,
+		Status:         org.Status,
+		DSN:            redactDSN(tc.DSN, tc.DSN),
+		DSNTemplated:   tc.templated,
+		ReplicaDSN:     redactDSN(tc.ReplicaDSN, tc.ReplicaDSN),
+		Driver:         openDialector(tc.DSN).Name(),
+		AllowedOrigins: tc.AllowedOrigins,
+		WebhookURL:     tc.WebhookURL,
+		MigrationHooks: tc.MigrationHooks,
+		Timezone:       tc.Timezone,
+		RateLimitRPS:   c.RateLimitRPS,
+		RateLimitBurst: c.RateLimitBurst,
+		MaxInFlight:    tc.maxInFlight(),
+	}
+	if e.AllowedOrigins == nil {
+		e.AllowedOrigins = c.CORSAllowedOrigins
+		e.AllowedOriginsDefault = true
+	}
+	if e.AllowedOrigins == nil {
+		e.AllowedOrigins = []string{}
+	}
+	if e.MigrationHooks == nil {
+		e.MigrationHooks = []string{}
+	}
+	if e.Timezone == "" {
+		e.Timezone = time.UTC.String()
+	}
+	if tc.WebhookSecret != "" {
+		e.WebhookSecret = redactedSecret
+	}
+	return e
+}
+
+// tenantConfig returns the configuration of the tenant resolved by
+// TenantMiddleware.
+func tenantConfig(ctx context.Context) TenantConfig {
+	tc, _ := ctx.Value(tenantConfigKey).(TenantConfig)
+	return tc
+}
+
+// tenantID returns the ID of the tenant resolved by TenantMiddleware.
+func tenantID(ctx context.Context) string {
+	id, _ := ctx.Value(tenantIDKey).(string)
+	return id
+}
+
+// location loads the tenant's time zone. "Local" is refused, since it
+// would be whatever the server runs in.
+func (tc TenantConfig) location() (*time.Location, error) {
+	if tc.Timezone == "" {
+		return time.UTC, nil
+	}
+	if tc.Timezone == "Local" {
+		return nil, errors.New("unknown time zone Local")
+	}
+	return time.LoadLocation(tc.Timezone)
+}
+
+// tenantLocation returns the time zone of the tenant resolved by
+// TenantMiddleware, UTC outside of tenant routes.
+func tenantLocation(ctx context.Context) *time.Location {
+	if loc, ok := ctx.Value(tenantLocationKey).(*time.Location); ok {
+		return loc
+	}
+	return time.UTC
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEffectiveTenantConfig(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		c.TenantDSNTemplate = "postgres://app:hunter2@db:5432/app?search_path={{.TenantID}}"
+		c.CORSAllowedOrigins = []string{"https://app.example.com"}
+	})
+	for _, org := range []Organization{
+		{ID: "acme", Name: "Acme", Config: `{"dsn": "postgres://acme:hunter2@db:5432/acme", "webhook_url": "https://hooks.example.com/", "webhook_secret": "s3cret", "timezone": "Asia/Almaty", "allowed_origins": ["https://acme.example.com"]}`},
+		{ID: "beta", Name: "Beta", Config: `{}`},
+	} {
+		org.Status = StatusActive
+		org.NameKey = organizationNameKey(org.Name)
+		if err := centralDB.Create(&org).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(id string) EffectiveTenantConfig {
+		t.Helper()
+		rec := s.admin("GET", "/admin/tenants/"+id+"/config", nil)
+		wantStatus(t, rec, http.StatusOK)
+		if body := rec.Body.String(); strings.Contains(body, "hunter2") || strings.Contains(body, "s3cret") {
+			t.Errorf("%s: config %s has a secret", id, body)
+		}
+		return decode[EffectiveTenantConfig](t, rec)
+	}
+
+	acme := get("acme")
+	if acme.Driver != "postgres" || acme.DSNTemplated || acme.Timezone != "Asia/Almaty" || acme.AllowedOriginsDefault {
+		t.Errorf("acme: %+v", acme)
+	}
+	if acme.DSN != "postgres://acme:"+redactedSecret+"@db:5432/acme" || acme.WebhookSecret != redactedSecret {
+		t.Errorf("acme: dsn %q, webhook secret %q", acme.DSN, acme.WebhookSecret)
+	}
+
+	// Everything beta has comes from defaults.
+	beta := get("beta")
+	if !beta.DSNTemplated || !strings.HasSuffix(beta.DSN, "search_path=beta") || beta.Timezone != "UTC" {
+		t.Errorf("beta: %+v", beta)
+	}
+	if !beta.AllowedOriginsDefault || !reflect.DeepEqual(beta.AllowedOrigins, []string{"https://app.example.com"}) {
+		t.Errorf("beta: allowed origins %v, default %v", beta.AllowedOrigins, beta.AllowedOriginsDefault)
+	}
+
+	wantStatus(t, s.do("GET", "/admin/tenants/acme/config", nil), http.StatusUnauthorized)
+	wantStatus(t, s.admin("GET", "/admin/tenants/gone/config", nil), http.StatusNotFound)
+}
+
+func TestParseTenantConfigTemplate(t *testing.T) {
+	newTestServer(t, func(c *Config) { c.TenantDSNTemplate = "postgres://app@db/app?search_path={{.TenantID}}" })
+	for _, tt := range []struct {
+		id, raw   string
+		dsn       string
+		templated bool
+	}{
+		{"acme", `{}`, "postgres://app@db/app?search_path=acme", true},
+		{"acme", ``, "postgres://app@db/app?search_path=acme", true},
+		{"acme", `{"dsn": "acme.db"}`, "acme.db", false},
+		{"acme", `acme.db`, "acme.db", false},
+	} {
+		tc, err := parseTenantConfig(tt.id, tt.raw)
+		if err != nil {
+			t.Errorf("%s %q: %v", tt.id, tt.raw, err)
+			continue
+		}
+		if tc.DSN != tt.dsn || tc.templated != tt.templated {
+			t.Errorf("%s %q: DSN = %q templated %v, want %q templated %v", tt.id, tt.raw, tc.DSN, tc.templated, tt.dsn, tt.templated)
+		}
+	}
+
+	for _, tt := range []struct{ tmpl, id string }{
+		{"app?search_path={{.TenantID}}", "acme&sslmode=disable"},
+		{"app?search_path={{.Tenant}}", "acme"},
+		{"{{if false}}x{{end}}", "acme"},
+		{"postgres://app@db:port/{{.TenantID}}", "acme"},
+	} {
+		if dsn, err := renderTenantDSN(tt.tmpl, tt.id); err == nil {
+			t.Errorf("%q for %s rendered %q, want an error", tt.tmpl, tt.id, dsn)
+		}
+	}
+
+	c := *cfg()
+	c.TenantDSNTemplate = ""
+	setConfig(&c)
+	if _, err := parseTenantConfig("acme", `{}`); !errors.Is(err, errTenantNotConfigured) {
+		t.Errorf("err = %v, want %v", err, errTenantNotConfigured)
+	}
+}
+
+func TestRenameTemplatedTenant(t *testing.T) {
+	s := newTestServer(t)
+	c := *cfg()
+	c.TenantDSNTemplate = filepath.Join(s.dir, "{{.TenantID}}.db")
+	setConfig(&c)
+	wantStatus(t, s.do("POST", "/organizations", Organization{ID: "acme", Name: "Acme", Config: "{}"}), http.StatusOK)
+	if err := s.tenantDB("acme").Create(&Kindergarten{ID: "k1", Name: "K1"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	wantStatus(t, s.admin("POST", "/admin/organizations/acme/rename", OrganizationRename{ID: "acme-corp"}), http.StatusOK)
+	// The database keeps the name rendered from the old ID.
+	wantStatus(t, s.tenant("acme-corp", "GET", "/kindergartens/k1", nil), http.StatusOK)
+	var org Organization
+	if err := centralDB.First(&org, "id = ?", "acme-corp").Error; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(org.Config, s.tenantDSN("acme")) {
+		t.Errorf("config = %s, want the DSN of acme pinned", org.Config)
+	}
+}
+
+func TestTenantTimezone(t *testing.T) {
+	s := newTestServer(t)
+	config, _ := json.Marshal(map[string]string{"dsn": s.tenantDSN("acme"), "timezone": "Asia/Almaty"})
+	wantStatus(t, s.do("POST", "/organizations", Organization{ID: "acme", Name: "Acme", Config: string(config)}), http.StatusOK)
+	s.createTenant("beta")
+
+	var zone string
+	handler := TenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zone = tenantLocation(r.Context()).String()
+	}))
+	for id, want := range map[string]string{"acme": "Asia/Almaty", "beta": "UTC"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Tenant-ID", id)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if zone != want {
+			t.Errorf("%s: zone = %q, want %s", id, zone, want)
+		}
+	}
+	if got := tenantLocation(context.Background()); got != time.UTC {
+		t.Errorf("zone outside tenant routes = %v, want UTC", got)
+	}
+
+	config, _ = json.Marshal(map[string]string{"dsn": s.tenantDSN("gamma"), "timezone": "Local"})
+	wantStatus(t, s.do("POST", "/organizations", Organization{ID: "gamma", Name: "Gamma", Config: string(config)}), http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// addActiveTenant stores an active organization whose database exists but
+// was never migrated, as one provisioned by hand would be.
+func (s *testServer) addActiveTenant(id, config string) {
+	s.t.Helper()
+	if err := os.WriteFile(s.tenantDSN(id), nil, 0o600); err != nil {
+		s.t.Fatal(err)
+	}
+	org := Organization{ID: id, Name: "Organization " + id, Config: config, Status: StatusActive}
+	if err := centralDB.Create(&org).Error; err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func TestTenantMigratedOnce(t *testing.T) {
+	s := newTestServer(t)
+	dsn := s.tenantDSN("acme")
+
+	// Each caller has its own connection, as each request does, counting
+	// the statements run through it.
+	counts := make([]atomic.Int32, 10)
+	var wg sync.WaitGroup
+	for i := range counts {
+		db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := func(*gorm.DB) { counts[i].Add(1) }
+		db.Callback().Raw().Before("gorm:raw").Register("test:count", count)
+		db.Callback().Row().Before("gorm:row").Register("test:count", count)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := migrateTenantOnce(TenantConfig{DSN: dsn}, db); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	migrated := 0
+	for i := range counts {
+		if counts[i].Load() > 0 {
+			migrated++
+		}
+	}
+	if migrated != 1 {
+		t.Errorf("migrated %d times, want once", migrated)
+	}
+
+	s.createTenant("acme")
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens", nil), http.StatusOK)
+}
+
+// hungDatabase listens for connections and never answers them, like a
+// database behind a dropped route. It returns its address and the number
+// of connections it accepted so far.
+func hungDatabase(t *testing.T) (string, func() int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		l.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	accepted := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(conns)
+	}
+	return l.Addr().String(), accepted
+}
+
+func TestTenantOpenTimeout(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.TenantOpenTimeout = 200 * time.Millisecond })
+	addr, _ := hungDatabase(t)
+	dsn := "postgres://app:secret@" + addr + "/acme?sslmode=disable"
+	org := Organization{ID: "acme", Name: "Acme", Config: `{"dsn": "` + dsn + `"}`}
+	if err := centralDB.Create(&org).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens", nil), http.StatusGatewayTimeout)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("request took %s", elapsed)
+	}
+}
+
+func TestTenantNotMigrated(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.AutoMigrate = false })
+	s.addActiveTenant("acme", s.tenantConfig("acme"))
+	db, err := gorm.Open(sqlite.Open(s.tenantDSN("acme")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Everything but the kindergartens.
+	err = db.AutoMigrate(&User{})
+	closeDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := s.tenant("acme", "GET", "/kindergartens", nil)
+	wantStatus(t, rec, http.StatusServiceUnavailable)
+	if !strings.Contains(rec.Body.String(), "tenant not migrated") {
+		t.Errorf("error %q doesn't say the tenant isn't migrated", rec.Body)
+	}
+}
+
+func TestTenantMaxConcurrentOpens(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		c.TenantMaxConcurrentOpens = 2
+		c.TenantOpenTimeout = 100 * time.Millisecond
+	})
+	s.addActiveTenant("warm", s.tenantConfig("warm"))
+	s.addActiveTenant("cold", s.tenantConfig("cold"))
+	wantStatus(t, s.tenant("warm", "GET", "/kindergartens", nil), http.StatusOK)
+
+	// Take both open slots: cold tenants wait for one, warm ones don't.
+	tenantOpenSlots <- struct{}{}
+	tenantOpenSlots <- struct{}{}
+	wantStatus(t, s.tenant("cold", "GET", "/kindergartens", nil), http.StatusGatewayTimeout)
+	wantStatus(t, s.tenant("warm", "GET", "/kindergartens", nil), http.StatusOK)
+
+	<-tenantOpenSlots
+	wantStatus(t, s.tenant("cold", "GET", "/kindergartens", nil), http.StatusOK)
+	<-tenantOpenSlots
+}
+
+func TestTenantSubResourceNotFound(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	if err := s.tenantDB("acme").Create(&Kindergarten{ID: "k1", Name: "K1"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		tenant, path, message string
+	}{
+		{"acme", "/kindergartens/missing", "kindergarten not found"},
+		{"acme", "/kindergartens/k1/classrooms/42", "classroom not found"},
+		{"acme", "/kindergartens/missing/classrooms/42", "kindergarten not found"},
+	} {
+		rec := s.tenant(tt.tenant, "GET", tt.path, nil)
+		wantStatus(t, rec, http.StatusNotFound)
+		if got := strings.TrimSpace(rec.Body.String()); got != tt.message {
+			t.Errorf("%s %s: error = %q, want %q", tt.tenant, tt.path, got, tt.message)
+		}
+	}
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens/k1", nil), http.StatusOK)
+	// An unknown tenant is refused before any lookup in a tenant database.
+	wantStatus(t, s.tenant("nobody", "GET", "/kindergartens/k1", nil), http.StatusBadRequest)
+}
+
+func TestTenantOpensCoalesced(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		c.TenantOpenTimeout = 200 * time.Millisecond
+		c.BreakerThreshold = 0
+	})
+	addr, accepted := hungDatabase(t)
+	s.addActiveTenant("acme", "postgres://app:secret@"+addr+"/acme?sslmode=disable")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rec := s.tenant("acme", "GET", "/kindergartens", nil); rec.Code != http.StatusGatewayTimeout {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := accepted(); got != 1 {
+		t.Errorf("connected %d times, want once", got)
+	}
+
+	// The failure isn't cached.
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens", nil), http.StatusGatewayTimeout)
+	if got := accepted(); got != 2 {
+		t.Errorf("connected %d times, want twice", got)
+	}
+}
+
+func TestTenantNotConfigured(t *testing.T) {
+	s := newTestServer(t)
+	for id, config := range map[string]string{"empty": "", "blank": "  \n", "nodsn": "{}"} {
+		org := Organization{ID: id, Name: id, Config: config, Status: StatusActive}
+		if err := centralDB.Create(&org).Error; err != nil {
+			t.Fatal(err)
+		}
+		rec := s.tenant(id, "GET", "/settings", nil)
+		wantStatus(t, rec, http.StatusServiceUnavailable)
+		if got := strings.TrimSpace(rec.Body.String()); got != "tenant not configured" {
+			t.Errorf("%s: error = %q", id, got)
+		}
+	}
+	if entries, _ := os.ReadDir(s.dir); len(entries) != 1 {
+		t.Errorf("files created: %v", entries)
+	}
+
+	rec := s.do("GET", "/organizations", nil)
+	wantStatus(t, rec, http.StatusOK)
+	for _, org := range decode[[]Organization](t, rec) {
+		if org.KindergartensError != "" || len(org.Kindergartens) > 0 {
+			t.Errorf("%s: kindergartens %v, error %q, want neither", org.ID, org.Kindergartens, org.KindergartensError)
+		}
+	}
+}
+
+func TestTenantDatabaseNotCreated(t *testing.T) {
+	s := newTestServer(t)
+	// A config pointing at a database that was never provisioned.
+	org := Organization{ID: "typo", Name: "Typo", Config: s.tenantConfig("typo"), Status: StatusActive}
+	if err := centralDB.Create(&org).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rec := s.tenant("typo", "GET", "/settings", nil)
+	wantStatus(t, rec, http.StatusServiceUnavailable)
+	if got := strings.TrimSpace(rec.Body.String()); got != "tenant database does not exist" {
+		t.Errorf("error = %q", got)
+	}
+	rec = s.admin("POST", "/admin/tenants/typo/migrate", nil)
+	wantStatus(t, rec, http.StatusInternalServerError)
+	if status := decode[TenantMigrationStatus](t, rec); !strings.HasPrefix(status.Error, "tenant database does not exist") {
+		t.Errorf("migration error = %q", status.Error)
+	}
+	if _, err := os.Stat(s.tenantDSN("typo")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("database created: %v", err)
+	}
+
+	// Provisioning creates it.
+	s.createTenant("acme")
+	if _, err := os.Stat(s.tenantDSN("acme")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithTenantTx(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	ctx := context.WithValue(context.Background(), "tenantDB", s.tenantDB("acme"))
+
+	errFail := errors.New("fail")
+	err := WithTenantTx(ctx, func(tx *gorm.DB) error {
+		if err := tx.Create(&Setting{Key: "theme", Value: []byte(`"dark"`)}).Error; err != nil {
+			return err
+		}
+		return errFail
+	})
+	if !errors.Is(err, errFail) {
+		t.Errorf("err = %v, want %v", err, errFail)
+	}
+	var n int64
+	s.tenantDB("acme").Model(&Setting{}).Count(&n)
+	if n != 0 {
+		t.Errorf("%d settings left by a rolled back transaction", n)
+	}
+
+	err = WithTenantTx(ctx, func(tx *gorm.DB) error {
+		return tx.Create(&Setting{Key: "theme", Value: []byte(`"dark"`)}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.tenantDB("acme").Model(&Setting{}).Count(&n)
+	if n != 1 {
+		t.Errorf("%d settings after a committed transaction, want 1", n)
+	}
+
+	if err := WithTenantTx(context.Background(), func(*gorm.DB) error { return nil }); err == nil {
+		t.Error("transaction run without a tenant database")
+	}
+}
+
+func TestTenantIDFormat(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+
+	for _, id := range []string{"acme corp", "acme' OR '1'='1", "acme/../beta", strings.Repeat("a", 65), "ácme"} {
+		rec := s.tenant(id, "GET", "/settings", nil)
+		wantStatus(t, rec, http.StatusBadRequest)
+		if got := strings.TrimSpace(rec.Body.String()); got != "tenant ID must be "+tenantIDFormatMessage {
+			t.Errorf("%q: error = %q", id, got)
+		}
+	}
+	wantStatus(t, s.tenant("  acme ", "GET", "/settings", nil), http.StatusOK)
+
+	for _, id := range []string{"acme corp", strings.Repeat("a", 65)} {
+		org := Organization{ID: id, Name: "Other", Config: s.tenantConfig("other")}
+		wantStatus(t, s.do("POST", "/organizations", org), http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// TenantUsage is the storage a tenant uses: the rows of each table of
+// tenantModels, and the size of its database.
+type TenantUsage struct {
+	OrganizationID string           `json:"organization_id"`
+	Rows           map[string]int64 `json:"rows"`
+	// SizeBytes is the approximate size of the database on disk, nil when
+	// the driver can't report it.
+	SizeBytes   *int64    `json:"size_bytes"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// usageCache keeps usage by organization ID for Config.StatsCacheTTL.
+var usageCache = struct {
+	sync.Mutex
+	m map[string]*TenantUsage
+}{m: map[string]*TenantUsage{}}
+
+// getTenantUsage reports the usage of one organization's tenant database,
+// measured within Config.TenantUsageTimeout.
+func getTenantUsage(w http.ResponseWriter, r *http.Request) {
+	org, err := organizationService.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	usageCache.Lock()
+	usage := usageCache.m[org.ID]
+	usageCache.Unlock()
+	if usage != nil && time.Since(usage.GeneratedAt) < cfg().StatsCacheTTL {
+		writeJSON(w, r, http.StatusOK, usage)
+		return
+	}
+
+	tc, err := parseTenantConfig(org.ID, org.Config)
+	if err != nil {
+		writeError(w, newError(ErrUnprocessable, "invalid tenant config", err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), cfg().TenantUsageTimeout)
+	defer cancel()
+	replica := tc.replica()
+	tenantDB, err := getTenantDB(ctx, replica)
+	if err != nil {
+		writeError(w, tenantDBErr(err))
+		return
+	}
+	usage, err = tenantUsage(ctx, org, tenantDB.WithContext(ctx), replica.DSN)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, newError(ErrTimeout, "timed out measuring tenant usage", err))
+		return
+	case err != nil:
+		writeError(w, newError(nil, "could not measure tenant usage", err))
+		return
+	}
+
+	usageCache.Lock()
+	usageCache.m[org.ID] = usage
+	usageCache.Unlock()
+	writeJSON(w, r, http.StatusOK, usage)
+}
+
+func tenantUsage(ctx context.Context, org Organization, db *gorm.DB, dsn string) (*TenantUsage, error) {
+	usage := &TenantUsage{OrganizationID: org.ID, Rows: map[string]int64{}}
+	for _, model := range tenantModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		var n int64
+		if err := db.Model(model).Count(&n).Error; err != nil {
+			return nil, err
+		}
+		usage.Rows[stmt.Schema.Table] = n
+	}
+
+	size, err := databaseSize(db, dsn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("tenant %s: could not get database size: %v", org.ID, err)
+	}
+	usage.SizeBytes = size
+	usage.GeneratedAt = time.Now().UTC()
+	return usage, nil
+}
+
+// databaseSize returns the size on disk of db, opened from dsn, as its
+// driver reports it, or nil for drivers that can't.
+func databaseSize(db *gorm.DB, dsn string) (*int64, error) {
+	var size int64
+	switch db.Dialector.Name() {
+	case "sqlite":
+		path, ok := sqlitePath(dsn)
+		if !ok {
+			return nil, nil
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		size = fi.Size()
+	case "postgres":
+		if err := db.Raw("SELECT pg_database_size(current_database())").Scan(&size).Error; err != nil {
+			return nil, err
+		}
+	case "mysql":
+		err := db.Raw("SELECT COALESCE(SUM(data_length + index_length), 0) FROM information_schema.tables WHERE table_schema = DATABASE()").Scan(&size).Error
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	return &size, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTenantUsage(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	for _, id := range []string{"k1", "k2"} {
+		wantStatus(t, s.tenant("acme", "POST", "/kindergartens", Kindergarten{ID: id, Name: "Kindergarten " + id}), http.StatusCreated)
+	}
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens/k1/classrooms", Classroom{Name: "Daisies"}), http.StatusCreated)
+	wantStatus(t, s.tenant("acme", "PUT", "/settings/theme", `"dark"`), http.StatusCreated)
+
+	rec := s.admin("GET", "/admin/tenants/acme/usage", nil)
+	wantStatus(t, rec, http.StatusOK)
+	usage := decode[TenantUsage](t, rec)
+	// Besides the two seed kindergartens.
+	for table, want := range map[string]int64{"kindergartens": 4, "classrooms": 1, "settings": 1} {
+		if got := usage.Rows[table]; got != want {
+			t.Errorf("%s rows = %d, want %d", table, got, want)
+		}
+	}
+	fi, err := os.Stat(s.tenantDSN("acme"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.SizeBytes == nil || *usage.SizeBytes != fi.Size() {
+		t.Errorf("size = %v, want %d", usage.SizeBytes, fi.Size())
+	}
+
+	wantStatus(t, s.admin("GET", "/admin/tenants/gone/usage", nil), http.StatusNotFound)
+}
+
+func TestTenantUsageCached(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.StatsCacheTTL = time.Minute })
+	s.createTenant("acme")
+	rows := func() int64 {
+		t.Helper()
+		rec := s.admin("GET", "/admin/tenants/acme/usage", nil)
+		wantStatus(t, rec, http.StatusOK)
+		return decode[TenantUsage](t, rec).Rows["kindergartens"]
+	}
+
+	before := rows()
+	wantStatus(t, s.tenant("acme", "POST", "/kindergartens", Kindergarten{Name: "Sunflower"}), http.StatusCreated)
+	if got := rows(); got != before {
+		t.Errorf("kindergartens = %d within the cache TTL, want %d", got, before)
+	}
+}
+
+func TestTenantUsageTimeout(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		c.TenantUsageTimeout = 100 * time.Millisecond
+		c.TenantOpenTimeout = 300 * time.Millisecond
+	})
+	addr, _ := hungDatabase(t)
+	dsn := "postgres://app:secret@" + addr + "/app?sslmode=disable"
+	org := Organization{ID: "hung", Name: "Hung", Config: dsn, Status: StatusActive}
+	org.NameKey = organizationNameKey(org.Name)
+	if err := centralDB.Create(&org).Error; err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, s.admin("GET", "/admin/tenants/hung/usage", nil), http.StatusGatewayTimeout)
+
+	// The open outlives the request; wait for it to give up so that it
+	// doesn't run into the next test.
+	<-tenantOpens.DoChan(dsn, func() (interface{}, error) { return nil, nil })
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// UserImportStatus is the outcome of one user of an import.
+type UserImportStatus string
+
+const (
+	ImportCreated  UserImportStatus = "created"
+	ImportInvalid  UserImportStatus = "invalid"
+	ImportConflict UserImportStatus = "conflict"
+	// ImportNotCreated marks valid users left out because others of an
+	// all-or-nothing import failed.
+	ImportNotCreated UserImportStatus = "not_created"
+)
+
+// UserImportResult reports on the user at Index in the imported array.
+type UserImportResult struct {
+	Index  int              `json:"index"`
+	Status UserImportStatus `json:"status"`
+	User   *User            `json:"user,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// UserImport is the response of POST /users/batch.
+type UserImport struct {
+	Created int                `json:"created"`
+	Results []UserImportResult `json:"results"`
+}
+
+// Import creates users as Create does, validating and hashing each one.
+// Users failing validation, or whose username is taken, by a stored user
+// or an earlier one of the batch, are reported as such. Unless
+// continueOnError is set, any of those fails the whole import and the
+// others are not created; the users are then created in one transaction.
+// With continueOnError every other user is created on its own.
+func (s *UserService) Import(ctx context.Context, users []User, continueOnError bool) (UserImport, error) {
+	res := UserImport{Results: make([]UserImportResult, len(users))}
+	seen := map[string]bool{}
+	var usernames []string
+	for i := range users {
+		// Imported users are new, whatever the body says.
+		users[i].ID, users[i].LastLoginAt = 0, nil
+		users[i].Username = normalizeUsername(users[i].Username)
+		res.Results[i] = UserImportResult{Index: i, Status: ImportCreated}
+		if err := users[i].validate(); err != nil {
+			res.Results[i].Status, res.Results[i].Error = ImportInvalid, errorMessage(err)
+			continue
+		}
+		if seen[users[i].Username] {
+			res.Results[i].Status, res.Results[i].Error = ImportConflict, "username appears earlier in the batch"
+			continue
+		}
+		seen[users[i].Username] = true
+		usernames = append(usernames, users[i].Username)
+	}
+
+	// Rows written before usernames were normalized are matched with LOWER,
+	// as UsernameTaken does.
+	var taken []string
+	if len(usernames) > 0 {
+		err := s.db.WithContext(ctx).Model(&User{}).Where("LOWER(username) IN ?", usernames).Pluck("LOWER(username)", &taken).Error
+		if err != nil {
+			return res, newError(nil, "could not import users", err)
+		}
+	}
+	failed := false
+	for i, r := range res.Results {
+		if r.Status == ImportCreated && slices.Contains(taken, users[i].Username) {
+			res.Results[i].Status, res.Results[i].Error = ImportConflict, "user already exists"
+		}
+		failed = failed || res.Results[i].Status != ImportCreated
+	}
+	if failed && !continueOnError {
+		for i, r := range res.Results {
+			if r.Status == ImportCreated {
+				res.Results[i].Status = ImportNotCreated
+			}
+		}
+		return res, nil
+	}
+
+	for i := range users {
+		if res.Results[i].Status == ImportCreated {
+			if err := hashUserPassword(&users[i]); err != nil {
+				return res, err
+			}
+		}
+	}
+	if continueOnError {
+		for i := range users {
+			if res.Results[i].Status != ImportCreated {
+				continue
+			}
+			err := s.db.WithContext(ctx).Create(&users[i]).Error
+			switch {
+			case errors.Is(err, gorm.ErrDuplicatedKey):
+				// Taken since it was checked.
+				res.Results[i].Status, res.Results[i].Error = ImportConflict, "user already exists"
+			case err != nil:
+				return res, newError(nil, "could not import users", err)
+			default:
+				res.Results[i].User = &users[i]
+				res.Created++
+			}
+		}
+		return res, nil
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := range users {
+			if err := tx.Create(&users[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return res, newError(ErrConflict, "a user was created with one of the usernames meanwhile", err)
+	case err != nil:
+		return res, newError(nil, "could not import users", err)
+	}
+	for i := range users {
+		res.Results[i].User = &users[i]
+	}
+	res.Created = len(users)
+	return res, nil
+}
+
+// importUsers creates the users of a JSON array, at most
+// Config.UserImportMaxSize of them, see UserService.Import.
+// ?continue_on_error=true creates the valid users even when others fail.
+// An import that fails as a whole answers 422, with the results telling
+// which users failed.
+func importUsers(w http.ResponseWriter, r *http.Request) {
+	var users []User
+	if err := decodeJSON(r, &users); err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(users) == 0 {
+		writeError(w, newError(ErrUnprocessable, "at least one user is required", nil))
+		return
+	}
+	if max := cfg().UserImportMaxSize; len(users) > max {
+		writeError(w, newError(ErrUnprocessable, fmt.Sprintf("at most %d users may be imported at once", max), nil))
+		return
+	}
+	continueOnError, _ := strconv.ParseBool(r.URL.Query().Get("continue_on_error"))
+
+	res, err := userService.Import(r.Context(), users, continueOnError)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if res.Created == 0 && !continueOnError {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, r, status, res)
+}
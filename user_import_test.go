@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestImportUsers(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.UserImportMaxSize = 4 })
+	wantStatus(t, s.do("POST", "/users", User{Username: "taken", Password: "secret"}), http.StatusOK)
+	statuses := func(res UserImport) []UserImportStatus {
+		var got []UserImportStatus
+		for _, r := range res.Results {
+			got = append(got, r.Status)
+		}
+		return got
+	}
+	countUsers := func() int64 {
+		var n int64
+		centralDB.Model(&User{}).Count(&n)
+		return n
+	}
+
+	// Sent as maps, since marshalling a User leaves out the password.
+	batch := []map[string]interface{}{
+		{"username": "erin", "password": "secret"},
+		{"username": "x", "password": "secret"},
+		{"username": "TAKEN", "password": "secret"},
+		{"username": "Erin", "password": "secret"},
+	}
+	rec := s.do("POST", "/users/batch", batch)
+	wantStatus(t, rec, http.StatusUnprocessableEntity)
+	res := decode[UserImport](t, rec)
+	if want := []UserImportStatus{ImportNotCreated, ImportInvalid, ImportConflict, ImportConflict}; res.Created != 0 || !reflect.DeepEqual(statuses(res), want) {
+		t.Errorf("all or nothing: %d created, statuses %v, want none and %v", res.Created, statuses(res), want)
+	}
+	if n := countUsers(); n != 1 {
+		t.Errorf("%d users after a failed import, want 1", n)
+	}
+
+	rec = s.do("POST", "/users/batch?continue_on_error=true", batch)
+	wantStatus(t, rec, http.StatusOK)
+	res = decode[UserImport](t, rec)
+	if want := []UserImportStatus{ImportCreated, ImportInvalid, ImportConflict, ImportConflict}; res.Created != 1 || !reflect.DeepEqual(statuses(res), want) {
+		t.Errorf("continue on error: %d created, statuses %v, want 1 and %v", res.Created, statuses(res), want)
+	}
+
+	rec = s.do("POST", "/users/batch", []map[string]interface{}{
+		{"id": 1, "username": "frank", "password": "secret"},
+		{"username": "grace", "password": "secret"},
+	})
+	wantStatus(t, rec, http.StatusOK)
+	res = decode[UserImport](t, rec)
+	if res.Created != 2 || res.Results[0].User == nil || res.Results[0].User.ID == 1 {
+		t.Errorf("clean import = %+v, want both created with new IDs", res)
+	}
+	if n := countUsers(); n != 4 {
+		t.Errorf("%d users, want 4", n)
+	}
+
+	wantStatus(t, s.do("POST", "/users/batch", []map[string]interface{}{}), http.StatusUnprocessableEntity)
+	wantStatus(t, s.do("POST", "/users/batch", append(batch, batch[0])), http.StatusUnprocessableEntity)
+}
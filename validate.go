@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+)
+
+// checkLength validates the length of a field in characters, not bytes, so
+// that limits mean the same for every script.
+func checkLength(field, value string, min, max int) error {
+	n := utf8.RuneCountInString(value)
+	switch {
+	case n < min:
+		return fieldError(field, fmt.Sprintf("must be at least %d characters", min), nil)
+	case max > 0 && n > max:
+		return fieldError(field, fmt.Sprintf("must be at most %d characters", max), nil)
+	}
+	return nil
+}
+
+func (o Organization) validate() error {
+	c := cfg()
+	if !tenantIDFormat.MatchString(o.ID) {
+		return fieldError("id", "must be "+tenantIDFormatMessage, nil)
+	}
+	if err := checkLength("name", o.Name, c.NameMinLength, c.NameMaxLength); err != nil {
+		return err
+	}
+	tc, err := parseTenantConfig(o.ID, o.Config)
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fieldError("config."+typeErr.Field, fmt.Sprintf("must be of type %s, not %s", jsonType(typeErr.Type), typeErr.Value), err)
+	case errors.As(err, &syntaxErr):
+		return fieldError("config", "must be a JSON object or a DSN", err)
+	case err != nil:
+		// A missing DSN or a broken DSN template is the server's to fix,
+		// and fails provisioning instead.
+		return nil
+	}
+	return tc.validate("config")
+}
+
+// validate checks the values of a parsed tenant config, naming the field
+// at fault by its path under prefix, such as "config.migration_hooks[1]".
+func (tc TenantConfig) validate(prefix string) error {
+	if _, err := tc.location(); err != nil {
+		return fieldError(prefix+".timezone", fmt.Sprintf("must be an IANA time zone, not %q", tc.Timezone), err)
+	}
+	if err := validateTenantDSN(prefix, tc); err != nil {
+		return err
+	}
+	for i, name := range tc.MigrationHooks {
+		if _, ok := tenantMigrationHooks[name]; !ok {
+			return fieldError(fmt.Sprintf("%s.migration_hooks[%d]", prefix, i), fmt.Sprintf("names unknown migration hook %q", name), nil)
+		}
+	}
+	if tc.WebhookURL != "" {
+		if err := validateWebhookURL(prefix+".webhook_url", tc.WebhookURL); err != nil {
+			return err
+		}
+	}
+	if tc.MaxInFlight < 0 {
+		return fieldError(prefix+".max_in_flight", "must not be negative", nil)
+	}
+	return nil
+}
+
+// validateTenantDSN checks that the tenant's databases use drivers of
+// Config.AllowedTenantDrivers.
+func validateTenantDSN(prefix string, tc TenantConfig) error {
+	allowed := cfg().AllowedTenantDrivers
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, f := range []struct{ name, dsn string }{{"dsn", tc.DSN}, {"replica_dsn", tc.ReplicaDSN}} {
+		if f.dsn == "" {
+			continue
+		}
+		if driver := openDialector(f.dsn).Name(); !slices.Contains(allowed, driver) {
+			return fieldError(prefix+"."+f.name, fmt.Sprintf("uses driver %s, which is not allowed for tenant databases", driver), nil)
+		}
+	}
+	return nil
+}
+
+func (u User) validate() error {
+	c := cfg()
+	return checkLength("username", normalizeUsername(u.Username), c.UsernameMinLength, c.UsernameMaxLength)
+}
+
+func (k Kindergarten) validate() error {
+	c := cfg()
+	return checkLength("name", k.Name, c.NameMinLength, c.NameMaxLength)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestOrganizationConfigValidated(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.ResponseEnvelope = true })
+	for _, tt := range []struct {
+		name   string
+		config string
+		field  string
+	}{
+		{"invalid JSON", `{"dsn": `, "config"},
+		{"wrong type", `{"dsn": 1}`, "config.dsn"},
+		{"unknown time zone", `{"dsn": "x.db", "timezone": "Mars/Olympus"}`, "config.timezone"},
+		{"unknown migration hook", `{"dsn": "x.db", "migration_hooks": ["nope"]}`, "config.migration_hooks[0]"},
+		{"negative max_in_flight", `{"dsn": "x.db", "max_in_flight": -1}`, "config.max_in_flight"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			org := map[string]string{"id": "acme", "name": "Acme", "config": tt.config}
+			rec := s.do("POST", "/organizations", org)
+			wantStatus(t, rec, http.StatusUnprocessableEntity)
+			if got := decode[errorEnvelope](t, rec).Field; got != tt.field {
+				t.Errorf("field = %q, want %q", got, tt.field)
+			}
+		})
+	}
+}
+
+func TestTenantDriversAllowed(t *testing.T) {
+	s := newTestServer(t, func(c *Config) {
+		c.ResponseEnvelope = true
+		c.AllowedTenantDrivers = []string{"sqlite"}
+	})
+	sqliteDSN := s.tenantDSN("acme")
+	for _, tt := range []struct {
+		config string
+		field  string
+	}{
+		{`{"dsn": "postgres://app@db/acme"}`, "config.dsn"},
+		{`{"dsn": "mysql://app@tcp(db)/acme"}`, "config.dsn"},
+		{fmt.Sprintf(`{"dsn": %q, "replica_dsn": "postgres://app@replica/acme"}`, sqliteDSN), "config.replica_dsn"},
+	} {
+		org := map[string]string{"id": "acme", "name": "Acme", "config": tt.config}
+		rec := s.do("POST", "/organizations", org)
+		wantStatus(t, rec, http.StatusUnprocessableEntity)
+		if got := decode[errorEnvelope](t, rec).Field; got != tt.field {
+			t.Errorf("%s: field = %q, want %q", tt.config, got, tt.field)
+		}
+	}
+
+	org := map[string]string{"id": "acme", "name": "Acme", "config": s.tenantConfig("acme")}
+	wantStatus(t, s.do("POST", "/organizations", org), http.StatusOK)
+	org["config"] = `{"dsn": "postgres://app@db/acme"}`
+	wantStatus(t, s.do("PUT", "/organizations/acme", org), http.StatusUnprocessableEntity)
+}
+
+func TestLengthLimits(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+	c := *cfg()
+	c.UsernameMinLength, c.UsernameMaxLength = 3, 5
+	c.NameMinLength, c.NameMaxLength = 2, 4
+	setConfig(&c)
+
+	n := 0
+	post := func(kind, value string) int {
+		n++
+		switch kind {
+		case "username":
+			return s.do("POST", "/users", User{Username: value}).Code
+		case "organization":
+			return s.do("POST", "/organizations", Organization{ID: fmt.Sprint("org", n), Name: value}).Code
+		default:
+			return s.tenant("acme", "POST", "/kindergartens", Kindergarten{Name: value}).Code
+		}
+	}
+	for _, tt := range []struct {
+		kind, value string
+		ok          bool
+	}{
+		{"username", "ab", false},
+		{"username", "abc", true},
+		{"username", "abcde", true},
+		{"username", "abcdef", false},
+		// Characters are counted, not bytes: this is 5 of them in 10 bytes.
+		{"username", "ééééé", true},
+		{"username", "éééééé", false},
+		{"organization", "A", false},
+		{"organization", "Ab", true},
+		{"organization", "Abcd", true},
+		{"organization", "Abcde", false},
+		{"organization", "日本語の", true},
+		{"kindergarten", "", false},
+		{"kindergarten", "Kg", true},
+		{"kindergarten", "Kind", true},
+		{"kindergarten", "Kinde", false},
+	} {
+		status := post(tt.kind, tt.value)
+		if (status != http.StatusUnprocessableEntity) != tt.ok {
+			t.Errorf("%s %q: status = %d, want ok %v", tt.kind, tt.value, status, tt.ok)
+		}
+	}
+}
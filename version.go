@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+type contextKey string
+
+const apiVersionKey contextKey = "apiVersion"
+
+const (
+	apiV1 = 1
+	apiV2 = 2
+
+	latestAPIVersion = apiV2
+)
+
+var vendorMediaType = regexp.MustCompile(`application/vnd\.app\.v(\d+)\+json`)
+
+// APIVersion resolves the API version requested through an
+// "Accept: application/vnd.app.vN+json" header, defaulting to v1.
+func APIVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := apiV1
+		if m := vendorMediaType.FindStringSubmatch(r.Header.Get("Accept")); m != nil {
+			v, err := strconv.Atoi(m[1])
+			if err != nil || v < apiV1 || v > latestAPIVersion {
+				httpError(w, "unsupported API version", http.StatusNotAcceptable)
+				return
+			}
+			version = v
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey, version)))
+	})
+}
+
+// forceAPIVersion pins the API version regardless of the Accept header. It
+// backs the /vN path prefixes.
+func forceAPIVersion(version int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey, version)))
+		})
+	}
+}
+
+func apiVersion(ctx context.Context) int {
+	if v, ok := ctx.Value(apiVersionKey).(int); ok {
+		return v
+	}
+	return apiV1
+}
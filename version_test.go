@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAPIVersionListShapes(t *testing.T) {
+	s := newTestServer(t)
+	s.createTenant("acme")
+
+	// Provisioning seeds two kindergartens.
+	rec := s.tenant("acme", "GET", "/kindergartens?limit=1", nil)
+	wantStatus(t, rec, http.StatusOK)
+	if got := decode[[]Kindergarten](t, rec); len(got) != 1 {
+		t.Errorf("v1 list has %d kindergartens, want 1", len(got))
+	}
+
+	type v2List struct {
+		Data []Kindergarten `json:"data"`
+		Meta Page           `json:"meta"`
+	}
+	for _, req := range []struct{ path, accept string }{
+		{"/kindergartens?limit=1", "application/vnd.app.v2+json"},
+		{"/v2/kindergartens?limit=1", ""},
+	} {
+		rec := s.tenant("acme", "GET", req.path, nil, "Accept", req.accept)
+		wantStatus(t, rec, http.StatusOK)
+		got := decode[v2List](t, rec)
+		if len(got.Data) != 1 || got.Meta.Limit != 1 || got.Meta.Total != 2 {
+			t.Errorf("%s: v2 list = %+v, want 1 kindergarten of 2 with limit 1", req.path, got)
+		}
+	}
+
+	wantStatus(t, s.tenant("acme", "GET", "/kindergartens", nil, "Accept", "application/vnd.app.v9+json"), http.StatusNotAcceptable)
+}
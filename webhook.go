@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// webhookTimeout bounds each delivery of a webhook. Failed deliveries are
+// retried by the outbox, see outboxRetryDelay.
+const webhookTimeout = 5 * time.Second
+
+// Events tenants are notified of.
+const (
+	EventOrganizationCreated = "organization.created"
+	EventOrganizationUpdated = "organization.updated"
+)
+
+// webhookClient only connects to addresses webhookTargetAllowed, whatever
+// the URL's host resolves to. It ignores the proxy settings of the
+// environment, which would have it connect to the proxy instead.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: webhookTimeout, Control: checkWebhookDial}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+	},
+}
+
+// errWebhookTarget is returned for webhooks that would reach an address
+// webhookTargetAllowed refuses.
+var errWebhookTarget = errors.New("webhook target is a private or loopback address")
+
+// webhookTargetAllowed reports whether webhooks may be sent to ip. Unless
+// Config.WebhookAllowPrivateTargets is set, loopback, private, link-local
+// and unspecified addresses are refused, so that a tenant can't make the
+// service call itself, its neighbours or a cloud metadata endpoint.
+func webhookTargetAllowed(ip net.IP) bool {
+	if cfg().WebhookAllowPrivateTargets {
+		return true
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast()
+}
+
+// checkWebhookDial refuses the connections of webhookClient to addresses
+// webhookTargetAllowed refuses, once the host is resolved.
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !webhookTargetAllowed(ip) {
+		return errWebhookTarget
+	}
+	return nil
+}
+
+// validateWebhookURL checks a tenant's webhook URL: an http or https URL
+// whose host, if it is an IP address or localhost, webhookTargetAllowed.
+// Host names are checked when webhooks are sent, see checkWebhookDial.
+func validateWebhookURL(field, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fieldError(field, "must be an http or https URL", err)
+	}
+	host := strings.ToLower(u.Hostname())
+	ip := net.ParseIP(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		ip = net.IPv6loopback
+	}
+	if ip != nil && !webhookTargetAllowed(ip) {
+		return fieldError(field, "must not point at a private or loopback address", errWebhookTarget)
+	}
+	return nil
+}
+
+// WebhookEvent is the body POSTed to a tenant's webhook URL. It is signed
+// with the tenant's webhook secret in the X-Webhook-Signature header, as
+// "sha256=" followed by the hex HMAC-SHA256 of the body.
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	TenantID  string      `json:"tenant_id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// deliverWebhook POSTs an encoded event to the tenant's webhook, once.
+func deliverWebhook(tc TenantConfig, eventType string, body []byte) error {
+	mac := hmac.New(sha256.New, []byte(tc.WebhookSecret))
+	mac.Write(body)
+	return postWebhook(tc.WebhookURL, eventType, body, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+func postWebhook(url, eventType string, body []byte, signature string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", eventType)
+	req.Header.Set("X-Webhook-Signature", signature)
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
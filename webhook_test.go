@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// webhookReceiver is a server receiving webhooks, which it checks against
+// secret.
+type webhookReceiver struct {
+	*httptest.Server
+	events chan WebhookEvent
+}
+
+func newWebhookReceiver(t *testing.T, secret string) *webhookReceiver {
+	t.Helper()
+	rcv := &webhookReceiver{events: make(chan WebhookEvent, 10)}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get("X-Webhook-Signature") != want {
+			t.Errorf("signature = %q, want %q", r.Header.Get("X-Webhook-Signature"), want)
+		}
+		var event WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("decoding webhook: %v", err)
+		}
+		if got := r.Header.Get("X-Webhook-Event"); got != event.Type {
+			t.Errorf("X-Webhook-Event = %q, want %q", got, event.Type)
+		}
+		rcv.events <- event
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+// webhookConfig returns the config of a tenant of s sending its webhooks
+// to url.
+func webhookConfig(s *testServer, id, url, secret string) string {
+	config, _ := json.Marshal(map[string]string{"dsn": s.tenantDSN(id), "webhook_url": url, "webhook_secret": secret})
+	return string(config)
+}
+
+func allowPrivateWebhooks(c *Config) {
+	c.WebhookAllowPrivateTargets = true
+}
+
+func TestWebhookDelivered(t *testing.T) {
+	s := newTestServer(t, allowPrivateWebhooks)
+	rcv := newWebhookReceiver(t, "s3cret")
+
+	// Not an Organization, which would be sent with the secret redacted.
+	org := map[string]string{"id": "acme", "name": "Acme", "config": webhookConfig(s, "acme", rcv.URL, "s3cret")}
+	wantStatus(t, s.do("POST", "/organizations", org), http.StatusOK)
+	if err := dispatchOutboxBatch(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-rcv.events:
+		if event.Type != EventOrganizationCreated || event.TenantID != "acme" {
+			t.Errorf("event = %s for %s, want %s for acme", event.Type, event.TenantID, EventOrganizationCreated)
+		}
+		if data, _ := json.Marshal(event.Data); strings.Contains(string(data), "s3cret") {
+			t.Errorf("event data %s has the secret", data)
+		}
+	default:
+		t.Fatal("no webhook received")
+	}
+}
+
+func TestWebhookSecretRedacted(t *testing.T) {
+	s := newTestServer(t, allowPrivateWebhooks)
+	rcv := newWebhookReceiver(t, "s3cret")
+	org := map[string]string{"id": "acme", "name": "Acme", "config": webhookConfig(s, "acme", rcv.URL, "s3cret")}
+	wantStatus(t, s.do("POST", "/organizations", org), http.StatusOK)
+
+	for _, rec := range []*httptest.ResponseRecorder{
+		s.do("GET", "/organizations/acme", nil),
+		s.do("GET", "/organizations", nil),
+		s.do("POST", "/organizations/batch-get", BatchGet{IDs: []string{"acme"}}),
+	} {
+		wantStatus(t, rec, http.StatusOK)
+		if body := rec.Body.String(); strings.Contains(body, "s3cret") || !strings.Contains(body, redactedSecret) {
+			t.Errorf("response %s doesn't redact the secret", body)
+		}
+	}
+
+	// Sending back the config as shown keeps the secret.
+	shown := decode[Organization](t, s.do("GET", "/organizations/acme", nil))
+	shown.Name = "Acme Corp"
+	wantStatus(t, s.do("PUT", "/organizations/acme", shown), http.StatusOK)
+	var stored Organization
+	if err := centralDB.First(&stored, "id = ?", "acme").Error; err != nil {
+		t.Fatal(err)
+	}
+	tc, err := parseTenantConfig(stored.ID, stored.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.WebhookSecret != "s3cret" {
+		t.Errorf("stored secret = %q, want s3cret", tc.WebhookSecret)
+	}
+}
+
+func TestWebhookURLValidated(t *testing.T) {
+	s := newTestServer(t)
+	for _, url := range []string{
+		"ftp://hooks.example.com/",
+		"hooks.example.com/path",
+		"http://127.0.0.1:8080/",
+		"http://localhost/",
+		"http://10.1.2.3/",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/",
+	} {
+		org := map[string]string{"id": "acme", "name": "Acme", "config": webhookConfig(s, "acme", url, "s3cret")}
+		rec := s.do("POST", "/organizations", org)
+		wantStatus(t, rec, http.StatusUnprocessableEntity)
+		if !strings.HasPrefix(rec.Body.String(), "config.webhook_url ") {
+			t.Errorf("%s: error %q doesn't name config.webhook_url", url, rec.Body.String())
+		}
+	}
+
+	// Host names pass, until they resolve to such an address.
+	if err := validateWebhookURL("config.webhook_url", "https://hooks.example.com/"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	newTestServer(t)
+	rcv := newWebhookReceiver(t, "s3cret")
+
+	// Host names can resolve to any address, so the address is checked
+	// when connecting.
+	err := postWebhook(rcv.URL, EventOrganizationCreated, []byte("{}"), "")
+	if !errors.Is(err, errWebhookTarget) {
+		t.Errorf("err = %v, want %v", err, errWebhookTarget)
+	}
+	select {
+	case <-rcv.events:
+		t.Error("webhook sent to a loopback address")
+	default:
+	}
+}